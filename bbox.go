@@ -59,25 +59,7 @@ func (bb BBox) Inner(m int) BBox {
 // Return a list of all the points in the bounding box.
 func (bb BBox) Points() PList {
 	points := PList{}
-	// Find min and max X & Y that completely covers the bounding box.
-	minx := bb[0].X
-	maxx := bb[0].X
-	miny := bb[0].Y
-	maxy := bb[0].Y
-	for i := 1; i < len(bb); i++ {
-		if minx > bb[i].X {
-			minx = bb[i].X
-		}
-		if maxx < bb[i].X {
-			maxx = bb[i].X
-		}
-		if miny > bb[i].Y {
-			miny = bb[i].Y
-		}
-		if maxy < bb[i].Y {
-			maxy = bb[i].Y
-		}
-	}
+	minx, miny, maxx, maxy := bb.limits()
 	// Create a list of all the points in the bounding box by iterating
 	// through all the points in the enclosing square and including
 	// the points that are in the bounding box.
@@ -92,9 +74,39 @@ func (bb BBox) Points() PList {
 	return points
 }
 
+// Return the min and max X & Y that completely covers the bounding box.
+func (bb BBox) limits() (minx, miny, maxx, maxy int) {
+	minx = bb[0].X
+	maxx = bb[0].X
+	miny = bb[0].Y
+	maxy = bb[0].Y
+	for i := 1; i < len(bb); i++ {
+		minx = min(minx, bb[i].X)
+		maxx = max(maxx, bb[i].X)
+		miny = min(miny, bb[i].Y)
+		maxy = max(maxy, bb[i].Y)
+	}
+	return
+}
+
+// span returns the number of points in the rectangle enclosing
+// the bounding box, which is the number of points that Points examines.
+func (bb BBox) span() int {
+	minx, miny, maxx, maxy := bb.limits()
+	return (maxx - minx + 1) * (maxy - miny + 1)
+}
+
 // In returns true if the point is in the bounding box.
 func (bb BBox) In(p Point) bool {
-	limit := Point{10000, p.Y}
+	// The ray from the point must extend past the right of the box.
+	maxx := bb[0].X
+	for i := 1; i < len(bb); i++ {
+		maxx = max(maxx, bb[i].X)
+	}
+	if p.X > maxx {
+		return false
+	}
+	limit := Point{maxx + 1, p.Y}
 	var count int
 	for i := range bb {
 		next := (i + 1) % len(bb)
@@ -120,6 +132,32 @@ func (bb BBox) In(p Point) bool {
 	return (count & 1) != 0
 }
 
+// Area returns the area of the bounding box, using the shoelace formula.
+func (bb BBox) Area() int {
+	var a int
+	for i := range bb {
+		next := (i + 1) % len(bb)
+		a += bb[i].X*bb[next].Y - bb[next].X*bb[i].Y
+	}
+	if a < 0 {
+		a = -a
+	}
+	return a / 2
+}
+
+// Overlaps returns true if the bounding boxes overlap.
+func (bb BBox) Overlaps(b BBox) bool {
+	for i := range bb {
+		for j := range b {
+			if intersect(bb[i], bb[(i+1)%len(bb)], b[j], b[(j+1)%len(b)]) {
+				return true
+			}
+		}
+	}
+	// No edges intersect, so check whether one box is inside the other.
+	return bb.In(b[0]) || b.In(bb[0])
+}
+
 // intersect returns true if the lines (p1,q1) and (p2,q2) intersect.
 func intersect(p1, q1, p2, q2 Point) bool {
 	o1 := orientation(p1, q1, p2)
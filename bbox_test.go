@@ -63,3 +63,23 @@ func TestBbox(t *testing.T) {
 		}
 	}
 }
+
+func TestOverlaps(t *testing.T) {
+	a := BBox{Point{5, 5}, Point{10, 5}, Point{10, 10}, Point{5, 10}}
+	if a.Area() != 25 {
+		t.Errorf("Expected area 25, got %d", a.Area())
+	}
+	b := a.Offset(4, 4)
+	if !a.Overlaps(b) || !b.Overlaps(a) {
+		t.Errorf("Expected overlap of %v and %v", a, b)
+	}
+	c := a.Offset(6, 0)
+	if a.Overlaps(c) {
+		t.Errorf("Unexpected overlap of %v and %v", a, c)
+	}
+	// Box completely inside another.
+	d := BBox{Point{0, 0}, Point{20, 0}, Point{20, 20}, Point{0, 20}}
+	if !a.Overlaps(d) || !d.Overlaps(a) {
+		t.Errorf("Expected overlap of %v and %v", a, d)
+	}
+}
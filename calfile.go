@@ -0,0 +1,179 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Version of the calibration file format.
+const calibrationVersion = 1
+
+// calFile is the JSON calibration file format.
+// The geometry of the digits is recorded as a fingerprint, so that
+// calibration data is not applied to digits that have changed.
+type calFile struct {
+	Version     int         `json:"version"`     // Format version
+	Fingerprint string      `json:"fingerprint"` // Fingerprint of the geometry of all the digits
+	Digits      []string    `json:"digits"`      // Fingerprint of each digit
+	Templates   []string    `json:"templates"`   // Template name of each digit
+	Threshold   int         `json:"threshold"`   // Threshold percentage
+	History     int         `json:"history"`     // Size of moving average history
+	Saved       time.Time   `json:"saved"`       // Time the file was saved
+	Levels      []calLevels `json:"levels"`      // Calibration levels, highest quality first
+}
+
+// calLevels is one saved set of calibration levels.
+type calLevels struct {
+	Quality int        `json:"quality"`
+	Good    int        `json:"good"`
+	Bad     int        `json:"bad"`
+	Updated time.Time  `json:"updated,omitempty"`
+	Digits  []calDigit `json:"digits"`
+}
+
+// calDigit holds the levels of the segments of one digit.
+type calDigit struct {
+	Segments []calSegment `json:"segments"`
+}
+
+// calSegment holds the moving average history of the min and max of one segment.
+type calSegment struct {
+	Min []int `json:"min"`
+	Max []int `json:"max"`
+}
+
+// Fingerprint returns a fingerprint of the digit geometry of the decoder.
+// Decoders with the same digit templates and locations have the same fingerprint.
+func (l *LcdDecoder) Fingerprint() string {
+	h := sha256.New()
+	for _, d := range l.Digits {
+		fmt.Fprintf(h, "%s\n", d.fingerprint())
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// fingerprint returns a fingerprint of the digit's template and location.
+func (d *Digit) fingerprint() string {
+	c := d.lcd.conf
+	s := fmt.Sprintf("%s,%v,%v,%v,%v,%d,%v,%v", c.Name, c.Tl, c.Tr, c.Br, c.Bl, c.Width, c.Dp, d.bb[TL])
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// saveJSON writes the highest quality calibration levels in JSON format.
+func (l *LcdDecoder) saveJSON(w io.Writer, max int) error {
+	cf := calFile{
+		Version:     calibrationVersion,
+		Fingerprint: l.Fingerprint(),
+		Threshold:   l.Threshold,
+		History:     l.History,
+		Saved:       time.Now(),
+	}
+	for _, d := range l.Digits {
+		cf.Digits = append(cf.Digits, d.fingerprint())
+		cf.Templates = append(cf.Templates, d.lcd.name)
+	}
+	worst, best := l.qualRange()
+	for qual := best; qual >= worst && (max <= 0 || len(cf.Levels) < max); qual-- {
+		for _, lev := range l.levelsMap[qual] {
+			cl := calLevels{Quality: lev.quality, Good: lev.good, Bad: lev.bad, Updated: lev.updated}
+			for _, d := range lev.digits {
+				var cd calDigit
+				for s := range d.segLevels {
+					sl := &d.segLevels[s]
+					cd.Segments = append(cd.Segments, calSegment{Min: sl.min.history, Max: sl.max.history})
+				}
+				cl.Digits = append(cl.Digits, cd)
+			}
+			cf.Levels = append(cf.Levels, cl)
+			if len(cf.Levels) == max {
+				break
+			}
+		}
+	}
+	return json.NewEncoder(w).Encode(&cf)
+}
+
+// restoreJSON reads calibration levels in JSON format.
+// If the digit geometry in the file does not match the decoder, only
+// the levels of the digits that are unchanged are restored, unless
+// StrictRestore is set, in which case an error is returned.
+func (l *LcdDecoder) restoreJSON(r io.Reader) ([]*levels, error) {
+	var cf calFile
+	if err := json.NewDecoder(r).Decode(&cf); err != nil {
+		return nil, err
+	}
+	if cf.Version < 1 || cf.Version > calibrationVersion {
+		return nil, fmt.Errorf("unsupported calibration version %d", cf.Version)
+	}
+	// Determine which digits can be restored.
+	var digits []int
+	for i, d := range l.Digits {
+		if i < len(cf.Digits) && cf.Digits[i] == d.fingerprint() {
+			digits = append(digits, i)
+		}
+	}
+	if cf.Fingerprint != l.Fingerprint() {
+		if l.StrictRestore {
+			return nil, fmt.Errorf("calibration does not match configuration (fingerprint %s, expected %s)", cf.Fingerprint, l.Fingerprint())
+		}
+		if len(digits) == 0 {
+			return nil, fmt.Errorf("calibration does not match any digits")
+		}
+	}
+	var calList []*levels
+	for i, cl := range cf.Levels {
+		if len(cl.Digits) != len(cf.Digits) {
+			return nil, fmt.Errorf("levels %d: digit count %d, expected %d", i, len(cl.Digits), len(cf.Digits))
+		}
+		if cl.Quality < 0 || cl.Quality > 100 {
+			return nil, fmt.Errorf("levels %d: quality %d out of range", i, cl.Quality)
+		}
+		if cl.Good < 0 || cl.Bad < 0 {
+			return nil, fmt.Errorf("levels %d: negative good (%d) or bad (%d) count", i, cl.Good, cl.Bad)
+		}
+		lev := l.newLevels()
+		lev.quality = cl.Quality
+		lev.good = cl.Good
+		lev.bad = cl.Bad
+		lev.updated = cl.Updated
+		for _, di := range digits {
+			cd := cl.Digits[di]
+			if len(cd.Segments) != SEGMENTS {
+				return nil, fmt.Errorf("levels %d: digit %d has %d segments", i, di, len(cd.Segments))
+			}
+			for s, cs := range cd.Segments {
+				sl := &lev.digits[di].segLevels[s]
+				for _, v := range cs.Min {
+					sl.min.Add(v)
+				}
+				for _, v := range cs.Max {
+					sl.max.Add(v)
+				}
+			}
+		}
+		calList = append(calList, lev)
+		if len(calList) == l.MaxLevels {
+			break
+		}
+	}
+	return calList, nil
+}
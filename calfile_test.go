@@ -0,0 +1,242 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd_test
+
+import (
+	"testing"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aamcrae/lcd"
+)
+
+type buffer struct {
+	bytes.Buffer
+}
+
+func (b *buffer) Close() error {
+	return nil
+}
+
+// Create a decoder for test1, calibrated using the test image.
+func calibratedDecoder(t testing.TB, conf lcd.LcdConfig) *lcd.LcdDecoder {
+	l, err := lcd.CreateLcdDecoder(conf)
+	if err != nil {
+		t.Fatalf("LCD config failed %v", err)
+	}
+	if err := l.Preset(readImage(t, "test1.jpg"), "12345678"); err != nil {
+		t.Fatalf("Preset: %v", err)
+	}
+	l.Good()
+	l.Recalibrate()
+	return l
+}
+
+func TestSaveRestore(t *testing.T) {
+	conf := readConfig(t, "test1.config")
+	l := calibratedDecoder(t, conf)
+	var b buffer
+	if err := l.Save(&b, 0); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	saved := b.String()
+	var hdr struct {
+		Version     int
+		Fingerprint string
+		Templates   []string
+	}
+	if err := json.Unmarshal([]byte(saved), &hdr); err != nil {
+		t.Fatalf("Saved calibration is not JSON: %v", err)
+	}
+	if hdr.Version != 1 || hdr.Fingerprint != l.Fingerprint() || len(hdr.Templates) != 8 {
+		t.Errorf("Unexpected header %+v", hdr)
+	}
+	img := readImage(t, "test1.jpg")
+	l2, _ := lcd.CreateLcdDecoder(conf)
+	n, err := l2.Restore(strings.NewReader(saved))
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 level restored, got %d", n)
+	}
+	if res := l2.Decode(img); res.Text != "12345678." {
+		t.Errorf("After restore, expected 12345678., got %s", res.Text)
+	}
+	// Change the location of the last digit; the other digits are restored.
+	conf.Digit[7].Coord[0] += 200
+	l3, _ := lcd.CreateLcdDecoder(conf)
+	if l3.Fingerprint() == l.Fingerprint() {
+		t.Errorf("Fingerprint unchanged")
+	}
+	if _, err := l3.Restore(strings.NewReader(saved)); err != nil {
+		t.Fatalf("Restore with changed digit: %v", err)
+	}
+	if res := l3.Decode(img); !strings.HasPrefix(res.Text, "1234567") {
+		t.Errorf("After partial restore, expected 1234567..., got %s", res.Text)
+	}
+	l4, _ := lcd.CreateLcdDecoder(conf)
+	l4.StrictRestore = true
+	if _, err := l4.Restore(strings.NewReader(saved)); err == nil {
+		t.Errorf("Expected error with strict restore")
+	}
+	// Incompatible version.
+	l5, _ := lcd.CreateLcdDecoder(conf)
+	if _, err := l5.Restore(strings.NewReader(strings.Replace(saved, `"version":1`, `"version":99`, 1))); err == nil {
+		t.Errorf("Expected error with unsupported version")
+	}
+}
+
+func TestRestoreLegacy(t *testing.T) {
+	conf := readConfig(t, "test1.config")
+	l := calibratedDecoder(t, conf)
+	var b buffer
+	if err := l.Save(&b, 1); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	var cal struct {
+		Levels []struct {
+			Digits []struct {
+				Segments []struct {
+					Min []int
+					Max []int
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(b.Bytes(), &cal); err != nil {
+		t.Fatalf("Saved calibration is not JSON: %v", err)
+	}
+	// Build a legacy CSV calibration from the saved levels.
+	avg := func(v []int) int {
+		var t int
+		for _, n := range v {
+			t += n
+		}
+		return t / len(v)
+	}
+	var csv strings.Builder
+	csv.WriteString("0,100\n")
+	for i, d := range cal.Levels[0].Digits {
+		for s, seg := range d.Segments {
+			fmt.Fprintf(&csv, "0,%d,%d,%d,%d\n", i, s, avg(seg.Min), avg(seg.Max))
+		}
+	}
+	l2, _ := lcd.CreateLcdDecoder(conf)
+	n, err := l2.Restore(strings.NewReader(csv.String()))
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 level restored, got %d", n)
+	}
+	if res := l2.Decode(readImage(t, "test1.jpg")); res.Text != "12345678." {
+		t.Errorf("After legacy restore, expected 12345678., got %s", res.Text)
+	}
+	if _, err := l2.Restore(strings.NewReader("0,100\n0,1,2\n")); err == nil {
+		t.Errorf("Expected error for bad legacy data")
+	}
+}
+
+func TestSaveToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lcdcal")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	conf := readConfig(t, "test1.config")
+	l := calibratedDecoder(t, conf)
+	f := filepath.Join(dir, "cal")
+	for i := 0; i < 4; i++ {
+		if err := l.SaveToFile(f, 0); err != nil {
+			t.Fatalf("SaveToFile: %v", err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 3 {
+		t.Errorf("Expected file and 2 backups, found %v", files)
+	}
+	// The mode of the file is kept when it is replaced.
+	if fi, err := os.Stat(f); err != nil {
+		t.Fatalf("%v", err)
+	} else if fi.Mode().Perm() != 0644 {
+		t.Errorf("New file mode: got %v, expected 0644", fi.Mode())
+	}
+	os.Chmod(f, 0640)
+	if err := l.SaveToFile(f, 0); err != nil {
+		t.Fatalf("SaveToFile: %v", err)
+	}
+	if fi, err := os.Stat(f); err != nil {
+		t.Fatalf("%v", err)
+	} else if fi.Mode().Perm() != 0640 {
+		t.Errorf("Replaced file mode: got %v, expected 0640", fi.Mode())
+	}
+	// Simulate a partial write of the main file.
+	if err := ioutil.WriteFile(f, []byte(`{"version":1,"finger`), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	l2, _ := lcd.CreateLcdDecoder(conf)
+	if _, err := l2.RestoreFromFile(f); err != nil {
+		t.Fatalf("RestoreFromFile did not use backup: %v", err)
+	}
+	if res := l2.Decode(readImage(t, "test1.jpg")); res.Text != "12345678." {
+		t.Errorf("After restore from backup, expected 12345678., got %s", res.Text)
+	}
+	// An empty file and no backups is an error.
+	l3, _ := lcd.CreateLcdDecoder(conf)
+	l3.Backups = 0
+	ioutil.WriteFile(f, nil, 0644)
+	if _, err := l3.RestoreFromFile(f); err == nil {
+		t.Errorf("Expected error restoring empty file")
+	}
+}
+
+func TestCalibrationSnapshot(t *testing.T) {
+	conf := readConfig(t, "test1.config")
+	l, _ := lcd.CreateLcdDecoder(conf)
+	if l.Calibration() != nil || len(l.Calibrations()) != 0 {
+		t.Errorf("Expected no calibration")
+	}
+	l = calibratedDecoder(t, conf)
+	pool := l.Calibrations()
+	if len(pool) != 1 || pool[0].Quality != 100 || pool[0].Good != 1 {
+		t.Fatalf("Unexpected calibration pool %+v", pool)
+	}
+	c := l.Calibration()
+	if len(c.Digits) != len(l.Digits) {
+		t.Fatalf("Expected %d digits, got %d", len(l.Digits), len(c.Digits))
+	}
+	for i, d := range c.Digits {
+		if d.Min >= d.Max || d.Threshold <= d.Min || d.Threshold >= d.Max {
+			t.Errorf("Digit %d: bad levels %d/%d/%d", i, d.Min, d.Threshold, d.Max)
+		}
+		for s, seg := range d.Segments {
+			if len(seg.MinHistory) == 0 || len(seg.MaxHistory) == 0 {
+				t.Errorf("Digit %d segment %s: bad levels %+v", i, lcd.SegmentName(s), seg)
+			}
+		}
+	}
+	// Snapshots are copies.
+	c.Digits[0].Segments[0].MinHistory[0] = -1
+	if l.Calibration().Digits[0].Segments[0].MinHistory[0] == -1 {
+		t.Errorf("Snapshot shares history with decoder")
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CaptureEntry is a captured frame, with its decode and scan data and
+// the label entered for it.
+type CaptureEntry struct {
+	HistoryEntry
+	Label string `json:"label,omitempty"` // True decode, in the form returned by DecodeResult.Marked
+	Name  string `json:"-"`               // File name of the entry
+}
+
+// Capture saves the frames of failing decodes (with invalid digits) and
+// borderline decodes (with a confidence below MinConfidence) to a directory,
+// so that they can be labelled later. Each frame is saved as a PNG image,
+// alongside a JSON file holding the CaptureEntry.
+// Capture is safe for concurrent use.
+type Capture struct {
+	Dir           string // Directory the frames are saved to
+	MinConfidence int    // Frames of decodes with a lower confidence are saved
+	MaxFrames     int    // Maximum number of frames in the directory (0 for no limit)
+
+	mu    sync.Mutex
+	count int // Count of frames in the directory, or -1 if not yet counted
+}
+
+// NewCapture creates a capture of frames to the directory, creating the
+// directory if necessary.
+func NewCapture(dir string) (*Capture, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Capture{Dir: dir, MinConfidence: 50, count: -1}, nil
+}
+
+// Add saves the frame if the decode failed or is borderline, returning
+// the entry saved, or nil if the frame was not saved. Once the directory
+// holds MaxFrames frames, no more are saved.
+func (c *Capture) Add(res *DecodeResult, frame image.Image, t time.Time) (*CaptureEntry, error) {
+	if res.Invalid == 0 && res.Confidence >= c.MinConfidence {
+		return nil, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.count < 0 {
+		m, err := filepath.Glob(filepath.Join(c.Dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		c.count = len(m)
+	}
+	if c.MaxFrames > 0 && c.count >= c.MaxFrames {
+		return nil, nil
+	}
+	base := frameName(c.Dir, t, ".json")
+	e := &CaptureEntry{HistoryEntry: *NewHistoryEntry(res, t), Name: filepath.Join(c.Dir, base+".json")}
+	e.Frame = filepath.Join(c.Dir, base+".png")
+	if err := SaveImage(e.Frame, frame); err != nil {
+		return nil, err
+	}
+	// The entry is written last, so that every entry has a frame.
+	if err := e.Save(); err != nil {
+		os.Remove(e.Frame)
+		return nil, err
+	}
+	c.count++
+	return e, nil
+}
+
+// Save writes the entry to its file.
+func (e *CaptureEntry) Save() error {
+	// The frame is stored relative to the directory of the entry.
+	se := *e
+	if rel, err := filepath.Rel(filepath.Dir(e.Name), e.Frame); err == nil {
+		se.Frame = rel
+	}
+	b, err := json.MarshalIndent(&se, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := writeTemp(e.Name, append(b, '\n'))
+	if err != nil {
+		return err
+	}
+	return renameTemp(tmp, e.Name)
+}
+
+// LoadCaptures reads the captured entries in the directory, in the order
+// they were captured. Frame names are resolved relative to the directory.
+func LoadCaptures(dir string) ([]*CaptureEntry, error) {
+	m, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(m)
+	var el []*CaptureEntry
+	for _, name := range m {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		e := &CaptureEntry{Name: name}
+		if err := json.Unmarshal(b, e); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		e.Frame = resolvePath(dir, e.Frame)
+		el = append(el, e)
+	}
+	return el, nil
+}
+
+// LabelPreset checks that the label (in the form returned by DecodeResult.Marked,
+// with '.' after a digit with the decimal point set) is a valid decode of the
+// digits, and returns the string of digits used to calibrate with the label via Preset.
+func LabelPreset(label string, digits int) (string, error) {
+	var p []byte
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if c == '.' && len(p) > 0 && label[i-1] != '.' {
+			continue
+		}
+		if _, ok := reverseTable[c]; !ok {
+			return "", fmt.Errorf("unknown character %q in %q", c, label)
+		}
+		p = append(p, c)
+	}
+	if len(p) != digits {
+		return "", fmt.Errorf("%q has %d digits, expected %d", label, len(p), digits)
+	}
+	return string(p), nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd_test
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aamcrae/lcd"
+)
+
+func TestCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	conf := readConfig(t, "test1.config")
+	l := calibratedDecoder(t, conf)
+	good := readImage(t, "test1.jpg")
+	c, err := lcd.NewCapture(dir)
+	if err != nil {
+		t.Fatalf("NewCapture: %v", err)
+	}
+	c.MinConfidence = 0
+	c.MaxFrames = 2
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	// A valid decode is not captured.
+	if e, err := c.Add(l.Decode(good), good, now); err != nil || e != nil {
+		t.Fatalf("Add good: got %v, %v", e, err)
+	}
+	// A different display has invalid digits, and is captured.
+	badImg := readImage(t, "lcd6.jpg")
+	bad := l.Decode(badImg)
+	if e, err := c.Add(bad, badImg, now); err != nil || e == nil {
+		t.Fatalf("Add bad: got %v, %v", e, err)
+	}
+	// A valid decode with a low confidence is captured, until the limit is reached.
+	c.MinConfidence = 101
+	for i := 0; i < 2; i++ {
+		e, err := c.Add(l.Decode(good), good, now)
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if (e != nil) != (i == 0) {
+			t.Errorf("Add %d: got %v", i, e)
+		}
+	}
+	el, err := lcd.LoadCaptures(dir)
+	if err != nil {
+		t.Fatalf("LoadCaptures: %v", err)
+	}
+	if len(el) != 2 || el[0].Name == el[1].Name || el[0].Frame == el[1].Frame {
+		t.Fatalf("Expected 2 different entries, got %+v", el)
+	}
+	if e := el[0]; e.Marked != bad.Marked() || len(e.Digits) != 8 || e.Digits[1].Segments[0] != bad.Scans[1].Segments[0] {
+		t.Errorf("Entry: got %+v", e)
+	}
+	e := el[1]
+	if e.Marked != "12345678." {
+		t.Errorf("Entry: got %+v", e)
+	}
+	// Label the frame, and use the label to calibrate an uncalibrated decoder.
+	u, err := lcd.CreateLcdDecoder(conf)
+	if err != nil {
+		t.Fatalf("CreateLcdDecoder: %v", err)
+	}
+	preset, err := lcd.LabelPreset("12345678.", len(u.Digits))
+	if err != nil || preset != "12345678" {
+		t.Fatalf("LabelPreset: got %q, %v", preset, err)
+	}
+	img, err := lcd.ReadImage(e.Frame)
+	if err != nil {
+		t.Fatalf("Frame: %v", err)
+	}
+	if err := u.Preset(img, preset); err != nil {
+		t.Fatalf("Preset: %v", err)
+	}
+	if m := u.Decode(img).Marked(); m != "12345678." {
+		t.Errorf("Decode after preset: got %q", m)
+	}
+	e.Label = "12345678."
+	if err := e.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	el, err = lcd.LoadCaptures(dir)
+	if err != nil {
+		t.Fatalf("LoadCaptures: %v", err)
+	}
+	if el[1].Label != "12345678." || el[1].Frame != e.Frame || el[0].Label != "" {
+		t.Errorf("After labelling: got %+v, %+v", el[0], el[1])
+	}
+	for _, label := range []string{"1234567", "123456789", "1234X678", "12..345678", ".12345678"} {
+		if _, err := lcd.LabelPreset(label, 8); err == nil {
+			t.Errorf("LabelPreset(%q) did not fail", label)
+		}
+	}
+	if p, err := lcd.LabelPreset(" . 12.3456", 8); err != nil || p != "  123456" {
+		t.Errorf("LabelPreset with blanks: got %q, %v", p, err)
+	}
+}
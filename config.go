@@ -16,38 +16,71 @@ package lcd
 
 import (
 	"fmt"
+	"image"
 )
 
+// LcdTemplate is the configuration of one digit template.
 type LcdTemplate struct {
-	Name  string
-	Tl    [2]int // Top left (origin)
-	Tr    [2]int // Top right
-	Br    [2]int // Bottom right
-	Bl    [2]int // Bottom left
-	Width int
-	Dp    []int
+	Name  string `yaml:"name" json:"name"`
+	Tl    [2]int `yaml:"tl,flow,omitempty" json:"tl,omitempty"` // Top left (origin)
+	Tr    [2]int `yaml:"tr,flow" json:"tr"`                     // Top right
+	Br    [2]int `yaml:"br,flow" json:"br"`                     // Bottom right
+	Bl    [2]int `yaml:"bl,flow" json:"bl"`                     // Bottom left
+	Width int    `yaml:"width" json:"width"`                    // Width of segments
+	Dp    []int  `yaml:"dp,flow,omitempty" json:"dp,omitempty"` // Decimal point (optional)
 }
 
+// Equal returns true if the template configurations are the same.
+func (t LcdTemplate) Equal(o LcdTemplate) bool {
+	if t.Name != o.Name || t.Tl != o.Tl || t.Tr != o.Tr || t.Br != o.Br || t.Bl != o.Bl || t.Width != o.Width || len(t.Dp) != len(o.Dp) {
+		return false
+	}
+	for i := range t.Dp {
+		if t.Dp[i] != o.Dp[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// DigitConfig is the configuration of one digit.
 type DigitConfig struct {
-	Lcd   string
-	Coord [2]int
+	Lcd   string `yaml:"lcd" json:"lcd"`          // Name of template
+	Coord [2]int `yaml:"coord,flow" json:"coord"` // Top left of digit
 }
 
 // Configuration block
 type LcdConfig struct {
-	Threshold int
-	Offset    [2]int
-	Lcd       []LcdTemplate
-	Digit     []DigitConfig
+	Threshold int           `yaml:"threshold,omitempty" json:"threshold,omitempty"` // On/off threshold percentage
+	History   int           `yaml:"history,omitempty" json:"history,omitempty"`     // Size of moving average history
+	MaxLevels int           `yaml:"maxlevels,omitempty" json:"maxlevels,omitempty"` // Maximum number of calibration levels
+	Inverse   bool          `yaml:"inverse,omitempty" json:"inverse,omitempty"`     // True if lighter is 'on' (e.g LED)
+	Offset    [2]int        `yaml:"offset,flow,omitempty" json:"offset,omitempty"`  // Offset added to all digit co-ordinates
+	Size      [2]int        `yaml:"size,flow,omitempty" json:"size,omitempty"`      // Expected image width and height (optional)
+	Lcd       []LcdTemplate `yaml:"lcd" json:"lcd"`
+	Digit     []DigitConfig `yaml:"digit" json:"digit"`
 }
 
 // Create a 7 segment decoder using the configuration data provided.
 func CreateLcdDecoder(conf LcdConfig) (*LcdDecoder, error) {
 	l := NewLcdDecoder()
+	if conf.Threshold < 0 || conf.Threshold > 100 {
+		return nil, fmt.Errorf("Threshold %d out of range (0-100)", conf.Threshold)
+	}
+	if conf.History < 0 || conf.MaxLevels < 0 {
+		return nil, fmt.Errorf("History (%d) and maxlevels (%d) must not be negative", conf.History, conf.MaxLevels)
+	}
 	// threshold is a percentage defining the point between the max and min.
 	if conf.Threshold != 0 {
 		l.Threshold = conf.Threshold
 	}
+	if conf.History != 0 {
+		l.History = conf.History
+	}
+	if conf.MaxLevels != 0 {
+		l.MaxLevels = conf.MaxLevels
+	}
+	l.Inverse = conf.Inverse
 	// lcd defines one 7 segment digit template.
 	// The format is a name followed by 4 pairs of x/y coordinates defining the corners
 	// of the digit (relative to the top left), followed by a value defining
@@ -74,5 +107,11 @@ func CreateLcdDecoder(conf LcdConfig) (*LcdDecoder, error) {
 			return nil, fmt.Errorf("Invalid digit config (index %d): %v", i, err)
 		}
 	}
+	// If the image size is declared, check that the digits fit within it.
+	if conf.Size[0] != 0 || conf.Size[1] != 0 {
+		if err := l.CheckBounds(image.Rect(0, 0, conf.Size[0], conf.Size[1])); err != nil {
+			return nil, err
+		}
+	}
 	return l, nil
 }
@@ -0,0 +1,246 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is the configuration file format shared by the utility
+// programs and applications using the library.
+// The file may be YAML or JSON, e.g:
+//
+//	source: http://metercam:8080/image.jpg
+//	rotate: 12.5
+//	crop: [100, 50, 800, 400]
+//	calibration: meter.cal
+//	config:
+//	  threshold: 50
+//	  lcd:
+//	    - name: A
+//	      ...
+//	  digit:
+//	    - lcd: A
+//	      ...
+//
+// Alternatively, the file may contain just the 'config' section
+// (i.e the LcdConfig) at the top level.
+// Unknown keys are treated as errors.
+type ConfigFile struct {
+	Source      string    `yaml:"source,omitempty" json:"source,omitempty"`           // URL or file name of image source
+	Rotate      float64   `yaml:"rotate,omitempty" json:"rotate,omitempty"`           // Rotation of image (degrees clockwise)
+	Crop        []int     `yaml:"crop,flow,omitempty" json:"crop,omitempty"`          // Crop of rotated image as x, y, width, height
+	Calibration string    `yaml:"calibration,omitempty" json:"calibration,omitempty"` // Calibration file
+	Config      LcdConfig `yaml:"config" json:"config"`                               // Decoder configuration
+}
+
+// LoadConfig reads and parses the configuration file. Files with a
+// '.json' suffix are parsed as JSON, otherwise the file is parsed as YAML.
+// Relative file names in the Source and Calibration entries are
+// resolved relative to the directory of the configuration file.
+func LoadConfig(name string) (*ConfigFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := ReadConfig(f, strings.HasSuffix(strings.ToLower(name), ".json"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	dir := filepath.Dir(name)
+	c.Calibration = resolvePath(dir, c.Calibration)
+	if !strings.Contains(c.Source, "://") {
+		c.Source = resolvePath(dir, c.Source)
+	}
+	return c, nil
+}
+
+// ReadConfig parses a configuration, either as JSON or YAML.
+func ReadConfig(r io.Reader, isJSON bool) (*ConfigFile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c := new(ConfigFile)
+	if isJSON {
+		err = readJSON(data, c)
+	} else {
+		err = readYAML(data, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Crop) != 0 && (len(c.Crop) != 4 || c.Crop[2] <= 0 || c.Crop[3] <= 0) {
+		return nil, fmt.Errorf("crop must be 4 values (x, y, width, height) with positive size")
+	}
+	return c, nil
+}
+
+// Decoder creates a decoder from the configuration. If a calibration
+// file is configured and exists, the calibration is restored from it.
+func (c *ConfigFile) Decoder() (*LcdDecoder, error) {
+	l, err := CreateLcdDecoder(c.Config)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Calibration) != 0 {
+		if _, err := os.Stat(c.Calibration); err == nil {
+			if _, err := l.RestoreFromFile(c.Calibration); err != nil {
+				return nil, fmt.Errorf("%s: %v", c.Calibration, err)
+			}
+		}
+	}
+	return l, nil
+}
+
+// Prepare applies the rotation and crop from the configuration to the image.
+// The cropped image retains the origin of the crop rectangle.
+func (c *ConfigFile) Prepare(img image.Image) image.Image {
+	img = RotateImage(img, c.Rotate)
+	if len(c.Crop) == 4 {
+		r := image.Rect(c.Crop[0], c.Crop[1], c.Crop[0]+c.Crop[2], c.Crop[1]+c.Crop[3])
+		if si, ok := img.(interface {
+			SubImage(image.Rectangle) image.Image
+		}); ok {
+			img = si.SubImage(r)
+		}
+	}
+	return img
+}
+
+// meterFile is the older YAML layout, where the source, rotation
+// and decoder configuration are held under a 'meter' key.
+type meterFile struct {
+	Meter struct {
+		Source    string  `yaml:"source,omitempty"`
+		Rotate    float64 `yaml:"rotate,omitempty"`
+		LcdConfig `yaml:",inline"`
+	} `yaml:"meter"`
+}
+
+// readYAML strictly parses the YAML data. If there is no 'config'
+// key at the top level, the data is parsed as a LcdConfig, or as the
+// older layout if there is a 'meter' key.
+func readYAML(data []byte, c *ConfigFile) error {
+	var n yaml.Node
+	if err := yaml.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	if len(n.Content) == 0 {
+		return fmt.Errorf("empty configuration")
+	}
+	var out interface{} = c
+	var m *meterFile
+	if yamlHasKey(n.Content[0], "meter") {
+		m = new(meterFile)
+		out = m
+	} else if !yamlHasKey(n.Content[0], "config") {
+		out = &c.Config
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(out); err != nil {
+		return err
+	}
+	if m != nil {
+		c.Source = m.Meter.Source
+		c.Rotate = m.Meter.Rotate
+		c.Config = m.Meter.LcdConfig
+	}
+	return nil
+}
+
+// yamlHasKey returns true if n is a mapping containing the key.
+func yamlHasKey(n *yaml.Node, key string) bool {
+	if n.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// readJSON strictly parses the JSON data. If there is no 'config'
+// key at the top level, the data is parsed as a LcdConfig.
+func readJSON(data []byte, c *ConfigFile) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	var out interface{} = c
+	if _, ok := m["config"]; !ok {
+		out = &c.Config
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(out)
+}
+
+// WriteConfig writes the configuration as JSON or YAML. If only the
+// LcdConfig is set, it is written at the top level.
+func WriteConfig(w io.Writer, c *ConfigFile, isJSON bool) error {
+	var out interface{} = c
+	if len(c.Source) == 0 && c.Rotate == 0 && len(c.Crop) == 0 && len(c.Calibration) == 0 {
+		out = &c.Config
+	}
+	if isJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// SaveConfig atomically replaces the configuration file. As with LoadConfig,
+// files with a '.json' suffix are written as JSON, otherwise as YAML.
+// Comments in an existing file are not preserved.
+func SaveConfig(name string, c *ConfigFile) error {
+	var b bytes.Buffer
+	if err := WriteConfig(&b, c, strings.HasSuffix(strings.ToLower(name), ".json")); err != nil {
+		return err
+	}
+	tmp, err := writeTemp(name, b.Bytes())
+	if err != nil {
+		return err
+	}
+	return renameTemp(tmp, name)
+}
+
+// resolvePath returns the file name relative to dir, unless it is absolute or empty.
+func resolvePath(dir, name string) string {
+	if len(name) == 0 || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
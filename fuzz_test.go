@@ -0,0 +1,161 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd_test
+
+import (
+	"testing"
+
+	"bytes"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/aamcrae/lcd"
+)
+
+// The fuzz targets are run with their seed corpus as part of the normal tests.
+// To fuzz, run e.g:
+//
+//	go test -fuzz=FuzzRestore
+
+func FuzzRestore(f *testing.F) {
+	conf := readConfig(f, "test1.config")
+	l := calibratedDecoder(f, conf)
+	var b buffer
+	if err := l.Save(&b, 0); err != nil {
+		f.Fatalf("Save: %v", err)
+	}
+	f.Add(b.Bytes())
+	f.Add([]byte("0,100\n0,0,0,1000,50000\n0,7,6,1000,50000\n1,90\n1,3,2,100,200\n"))
+	f.Add([]byte("0,50\n"))
+	f.Add([]byte("0,2000000000\n"))
+	f.Add([]byte(`{"version":1,"levels":[{"quality":100,"digits":[]}]}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		l, err := lcd.CreateLcdDecoder(conf)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, err := l.Restore(bytes.NewReader(data)); err != nil {
+			return
+		}
+		// A successful restore must be able to be saved and restored.
+		var b buffer
+		if err := l.Save(&b, 0); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		l2, _ := lcd.CreateLcdDecoder(conf)
+		if _, err := l2.Restore(&b); err != nil {
+			t.Fatalf("Restore of saved calibration: %v", err)
+		}
+		l.Decode(image.NewGray(image.Rect(0, 0, 64, 64)))
+		l.Good()
+		l.Recalibrate()
+		l.Calibrations()
+	})
+}
+
+func FuzzBBox(f *testing.F) {
+	f.Add(int16(0), int16(0), int16(30), int16(0), int16(30), int16(70), int16(0), int16(70), int16(10), int16(10))
+	f.Add(int16(5), int16(5), int16(5), int16(5), int16(5), int16(5), int16(5), int16(5), int16(5), int16(5))
+	f.Add(int16(0), int16(0), int16(30), int16(30), int16(0), int16(30), int16(30), int16(0), int16(15), int16(15))
+	f.Fuzz(func(t *testing.T, x0, y0, x1, y1, x2, y2, x3, y3, px, py int16) {
+		// Keep the box small enough to enumerate the points.
+		c := func(v int16) int {
+			return int(v % 256)
+		}
+		bb := lcd.BBox{{c(x0), c(y0)}, {c(x1), c(y1)}, {c(x2), c(y2)}, {c(x3), c(y3)}}
+		r := image.Rectangle{}
+		for _, p := range bb {
+			r = r.Union(image.Rect(p.X, p.Y, p.X+1, p.Y+1))
+		}
+		for _, p := range bb.Points() {
+			if !(image.Point{p.X, p.Y}).In(r) {
+				t.Fatalf("%v: point %v outside of box bounds %v", bb, p, r)
+			}
+		}
+		p := lcd.Point{c(px), c(py)}
+		if bb.In(p) && !(image.Point{p.X, p.Y}).In(r) {
+			t.Fatalf("%v: point %v is in box, but outside bounds %v", bb, p, r)
+		}
+		bb.Inner(c(px))
+		bb.Area()
+		bb.Overlaps(lcd.BBox{bb[2], bb[3], bb[0], p})
+	})
+}
+
+func FuzzAdjustSplit(f *testing.F) {
+	f.Add(0, 0, 30, 40, 10, 2)
+	f.Add(5, 5, 5, 5, 3, 0)
+	f.Add(-10, 100, 20, -50, -5, -3)
+	f.Fuzz(func(t *testing.T, sx, sy, ex, ey, adj, sections int) {
+		// Limit the co-ordinates to avoid overflow.
+		s := lcd.Point{sx % 100000, sy % 100000}
+		e := lcd.Point{ex % 100000, ey % 100000}
+		adj = adj % 100000
+		r := image.Rect(s.X, s.Y, e.X, e.Y).Canon()
+		r.Max = r.Max.Add(image.Point{1, 1})
+		p := lcd.Adjust(s, e, adj)
+		if adj >= 0 && adj*adj <= (e.X-s.X)*(e.X-s.X)+(e.Y-s.Y)*(e.Y-s.Y) && !(image.Point{p.X, p.Y}).In(r) {
+			t.Fatalf("Adjust(%v, %v, %d) = %v, outside of line", s, e, adj, p)
+		}
+		sections = sections % 1000
+		pl := lcd.Split(s, e, sections)
+		if sections >= 1 && len(pl) != sections-1 {
+			t.Fatalf("Split(%v, %v, %d) returned %d points", s, e, sections, len(pl))
+		}
+		for _, p := range pl {
+			if !(image.Point{p.X, p.Y}).In(r) {
+				t.Fatalf("Split(%v, %v, %d): point %v outside of line", s, e, sections, p)
+			}
+		}
+	})
+}
+
+func FuzzConfig(f *testing.F) {
+	names, err := filepath.Glob(filepath.Join("testdata", "*.config"))
+	if err != nil {
+		f.Fatalf("%v", err)
+	}
+	for _, n := range names {
+		data, err := ioutil.ReadFile(n)
+		if err != nil {
+			f.Fatalf("%v", err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte("lcd:\n  - name: A\n    tr: [0,0]\n    br: [0,0]\n    bl: [0,0]\n    width: 3\ndigit:\n  - lcd: A\n    coord: [0,0]\n"))
+	f.Add([]byte("rotate: 45\ncrop: [1,2,30,40]\nconfig:\n  threshold: -1\n"))
+	f.Add([]byte("history: -3\nlcd:\n  - name: A\n    tr: [10,0]\n    br: [10,20]\n    bl: [0,20]\n    width: 2\ndigit:\n  - lcd: A\n    coord: [0,0]\n"))
+	f.Add([]byte("lcd:\n  - name: A\n    tr: [100000000,0]\n    br: [10,20]\n    bl: [0,20]\n    width: 2\n    dp: [1]\ndigit:\n  - lcd: A\n    coord: [0,0]\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c, err := lcd.ReadConfig(bytes.NewReader(data), false)
+		if err != nil {
+			return
+		}
+		lcd.ValidateConfig(c.Config)
+		l, err := lcd.CreateLcdDecoder(c.Config)
+		if err != nil {
+			return
+		}
+		// Recalibrating before any decodes must not fail.
+		l.Recalibrate()
+		img := c.Prepare(image.NewGray(image.Rect(0, 0, 64, 64)))
+		l.CheckBounds(img.Bounds())
+		l.Validate()
+		l.Decode(img)
+		l.Good()
+		l.Recalibrate()
+	})
+}
@@ -1,10 +1,13 @@
 module github.com/aamcrae/lcd
 
-go 1.16
+go 1.18
 
 require (
 	github.com/fogleman/gg v1.3.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d // indirect
-	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,273 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Names of the history log and the directory of saved frames in a history directory.
+const (
+	HistoryLog    = "history.jsonl"
+	HistoryFrames = "frames"
+)
+
+// HistoryEntry is the record of one decode in the reading history.
+type HistoryEntry struct {
+	Time       time.Time      `json:"time"`            // Time of the frame
+	Text       string         `json:"text"`            // Decoded string of digits
+	Marked     string         `json:"marked"`          // Decoded digits with invalid digits as 'X'
+	Invalid    int            `json:"invalid"`         // Count of invalid digits
+	Confidence int            `json:"confidence"`      // Lowest confidence of all the digits
+	Digits     []HistoryDigit `json:"digits"`          // Decode and scan of each digit
+	Frame      string         `json:"frame,omitempty"` // Saved frame, relative to the history directory
+}
+
+// HistoryDigit is the decode and scan of one digit.
+type HistoryDigit struct {
+	Char       string `json:"char"`       // Decoded character, or empty if invalid
+	Valid      bool   `json:"valid"`      // True if the decode was successful
+	DP         bool   `json:"dp"`         // True if the decimal point is set
+	Confidence int    `json:"confidence"` // Confidence (0-100) in the segment states
+	Segments   []int  `json:"segments"`   // Averaged value for each segment
+	DPLevel    int    `json:"dp_level"`   // Decimal point sample
+	Mask       int    `json:"mask"`       // Mask of segment bits
+}
+
+// NewHistoryEntry creates a history entry from the decode result of a frame taken at time t.
+func NewHistoryEntry(res *DecodeResult, t time.Time) *HistoryEntry {
+	e := &HistoryEntry{
+		Time:       t,
+		Text:       res.Text,
+		Marked:     res.Marked(),
+		Invalid:    res.Invalid,
+		Confidence: res.Confidence,
+	}
+	for i, d := range res.Decodes {
+		hd := HistoryDigit{Char: d.Str, Valid: d.Valid, DP: d.DP, Confidence: d.Confidence}
+		if i < len(res.Scans) {
+			s := res.Scans[i]
+			hd.Segments = s.Segments
+			hd.DPLevel = s.DP
+			hd.Mask = s.Mask
+		}
+		e.Digits = append(e.Digits, hd)
+	}
+	return e
+}
+
+// ReadingLog records the history of decodes as JSON lines (HistoryEntry) in
+// a log file in a directory. When the log exceeds MaxSize, it is rotated,
+// keeping Backups old logs (named with a suffix of .1, .2 etc.).
+// If SaveFrames is set, the frames of invalid decodes and of decodes with a
+// confidence below MinConfidence are saved (as PNG images in the frames
+// subdirectory), so that they can be replayed later. Saved frames are removed
+// when the log that refers to them is discarded.
+// ReadingLog is safe for concurrent use.
+type ReadingLog struct {
+	Dir           string // Directory of the log and the saved frames
+	MaxSize       int64  // Size of the log before it is rotated (0 for no rotation)
+	Backups       int    // Number of rotated logs kept
+	SaveFrames    bool   // Save the frames of invalid and low confidence decodes
+	MinConfidence int    // Frames of decodes with a lower confidence are saved
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewReadingLog creates a reading log in the directory, creating the
+// directory if necessary.
+func NewReadingLog(dir string) (*ReadingLog, error) {
+	if err := os.MkdirAll(filepath.Join(dir, HistoryFrames), 0755); err != nil {
+		return nil, err
+	}
+	return &ReadingLog{Dir: dir, MaxSize: 10 << 20, Backups: 4}, nil
+}
+
+// Add appends the decode result of the frame to the log, saving the frame
+// if required. The frame should be the image as read (before rotation or
+// cropping), so that it can be replayed with a different configuration.
+func (h *ReadingLog) Add(res *DecodeResult, frame image.Image, t time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := NewHistoryEntry(res, t)
+	if h.SaveFrames && frame != nil && (res.Invalid != 0 || res.Confidence < h.MinConfidence) {
+		name := filepath.Join(HistoryFrames, frameName(filepath.Join(h.Dir, HistoryFrames), t, ".png")+".png")
+		if err := SaveImage(filepath.Join(h.Dir, name), frame); err != nil {
+			return err
+		}
+		e.Frame = name
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if h.MaxSize > 0 && h.f != nil && h.size > 0 && h.size+int64(len(b)) > h.MaxSize {
+		if err := h.rotate(); err != nil {
+			return err
+		}
+	}
+	if h.f == nil {
+		if err := h.open(); err != nil {
+			return err
+		}
+	}
+	n, err := h.f.Write(b)
+	h.size += int64(n)
+	return err
+}
+
+// Close closes the log.
+func (h *ReadingLog) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.f == nil {
+		return nil
+	}
+	err := h.f.Close()
+	h.f = nil
+	return err
+}
+
+// Return an unused name (without the suffix) in the directory for a frame
+// taken at time t. Frames may have the same time (e.g when read repeatedly
+// from a file), so a sequence number is added.
+func frameName(dir string, t time.Time, suffix string) string {
+	base := t.Format("20060102-150405.000")
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s-%03d", base, i)
+		if _, err := os.Stat(filepath.Join(dir, name+suffix)); os.IsNotExist(err) {
+			return name
+		}
+	}
+}
+
+// Open the log for appending.
+func (h *ReadingLog) open() error {
+	f, err := os.OpenFile(filepath.Join(h.Dir, HistoryLog), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	h.f = f
+	h.size = fi.Size()
+	return nil
+}
+
+// Rotate the logs, discarding the oldest log and its saved frames.
+func (h *ReadingLog) rotate() error {
+	if err := h.f.Close(); err != nil {
+		return err
+	}
+	h.f = nil
+	name := filepath.Join(h.Dir, HistoryLog)
+	oldest := name
+	if h.Backups > 0 {
+		oldest = backupName(name, h.Backups)
+	}
+	h.removeFrames(oldest)
+	os.Remove(oldest)
+	for i := h.Backups; i > 1; i-- {
+		os.Rename(backupName(name, i-1), backupName(name, i))
+	}
+	if h.Backups > 0 {
+		return os.Rename(name, backupName(name, 1))
+	}
+	return nil
+}
+
+// Remove the frames saved by the entries of the log.
+func (h *ReadingLog) removeFrames(name string) {
+	el, err := ReadHistory(name)
+	if err != nil {
+		return
+	}
+	for _, e := range el {
+		if len(e.Frame) != 0 {
+			os.Remove(filepath.Join(h.Dir, e.Frame))
+		}
+	}
+}
+
+// ReadHistory reads the entries of a history log file. Frame names are
+// relative to the directory of the log.
+func ReadHistory(name string) ([]*HistoryEntry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var el []*HistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	var line int
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		e := new(HistoryEntry)
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %v", name, line, err)
+		}
+		el = append(el, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return el, nil
+}
+
+// LoadHistory reads the entries of the current and rotated logs in the
+// history directory, oldest first. Frame names are resolved relative to the directory.
+func LoadHistory(dir string) ([]*HistoryEntry, error) {
+	name := filepath.Join(dir, HistoryLog)
+	var logs []string
+	for i := 1; ; i++ {
+		b := backupName(name, i)
+		if _, err := os.Stat(b); err != nil {
+			break
+		}
+		logs = append([]string{b}, logs...)
+	}
+	if _, err := os.Stat(name); err == nil || len(logs) == 0 {
+		logs = append(logs, name)
+	}
+	var el []*HistoryEntry
+	for _, l := range logs {
+		e, err := ReadHistory(l)
+		if err != nil {
+			return nil, err
+		}
+		el = append(el, e...)
+	}
+	for _, e := range el {
+		e.Frame = resolvePath(dir, e.Frame)
+	}
+	return el, nil
+}
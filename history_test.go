@@ -0,0 +1,107 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd_test
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aamcrae/lcd"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	conf := readConfig(t, "test1.config")
+	l := calibratedDecoder(t, conf)
+	good := readImage(t, "test1.jpg")
+	bad := readImage(t, "lcd6.jpg")
+	h, err := lcd.NewReadingLog(dir)
+	if err != nil {
+		t.Fatalf("NewReadingLog: %v", err)
+	}
+	h.SaveFrames = true
+	h.MinConfidence = 0
+	h.Backups = 1
+	start := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	// Add a good and a bad decode, and check that only the bad frame is saved.
+	if err := h.Add(l.Decode(good), good, start); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := h.Add(l.Decode(bad), bad, start.Add(time.Second)); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	el, err := lcd.LoadHistory(dir)
+	if err != nil {
+		t.Fatalf("LoadHistory: %v", err)
+	}
+	if len(el) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(el))
+	}
+	if e := el[0]; e.Marked != "12345678." || e.Invalid != 0 || len(e.Frame) != 0 || !e.Time.Equal(start) {
+		t.Errorf("Entry 0: got %+v", e)
+	}
+	if len(el[0].Digits) != 8 || len(el[0].Digits[0].Segments) != lcd.SEGMENTS || !el[0].Digits[0].Valid {
+		t.Errorf("Entry 0 digits: got %+v", el[0].Digits)
+	}
+	e := el[1]
+	if e.Invalid == 0 || len(e.Frame) == 0 {
+		t.Fatalf("Entry 1: got %+v", e)
+	}
+	img, err := lcd.ReadImage(e.Frame)
+	if err != nil {
+		t.Fatalf("Saved frame: %v", err)
+	}
+	if img.Bounds() != bad.Bounds() {
+		t.Errorf("Saved frame bounds %v, expected %v", img.Bounds(), bad.Bounds())
+	}
+	// Replaying the saved frame gives the same decode.
+	if m := l.Decode(img).Marked(); m != e.Marked {
+		t.Errorf("Replay: got %q, expected %q", m, e.Marked)
+	}
+	// Rotate the log before each entry, so that the log with the saved frame is discarded.
+	h.MaxSize = 1
+	for i := 0; i < 2; i++ {
+		if err := h.Add(l.Decode(good), good, start.Add(time.Duration(i+2)*time.Second)); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if _, err := os.Stat(e.Frame); !os.IsNotExist(err) {
+		t.Errorf("Saved frame %s not removed (%v)", e.Frame, err)
+	}
+	el, err = lcd.LoadHistory(dir)
+	if err != nil {
+		t.Fatalf("LoadHistory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, lcd.HistoryLog+".2")); !os.IsNotExist(err) {
+		t.Errorf("Unexpected second backup (%v)", err)
+	}
+	if len(el) != 2 || !el[0].Time.Equal(start.Add(2*time.Second)) || !el[1].Time.Equal(start.Add(3*time.Second)) {
+		t.Errorf("After rotation: got %d entries", len(el))
+		for _, e := range el {
+			t.Logf("%+v", e)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Boundary used to separate the frames of the MJPEG stream served by ImageServer.
+const imageServerBoundary = "lcdframe"
+
+// ImageServer is a local HTTP server that serves a sequence of images, as a
+// stand-in for a camera in tests and demonstrations.
+// Each request of the snapshot URL returns the next image in turn, and
+// the stream URL returns the images repeatedly as a MJPEG stream.
+type ImageServer struct {
+	URL string // Base URL of the server
+
+	mu       sync.Mutex
+	frames   [][]byte
+	next     int
+	interval time.Duration
+	limit    int
+	delay    time.Duration
+	fail     int
+	requests int
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewImageServer starts a server on a local port, serving the images (which are
+// encoded as JPEG).
+func NewImageServer(images ...image.Image) (*ImageServer, error) {
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no images to serve")
+	}
+	s := &ImageServer{}
+	for _, img := range images {
+		var b bytes.Buffer
+		if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 95}); err != nil {
+			return nil, err
+		}
+		s.frames = append(s.frames, b.Bytes())
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s.listener = l
+	s.URL = "http://" + l.Addr().String()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.jpg", s.snapshot)
+	mux.HandleFunc("/stream.mjpeg", s.stream)
+	s.server = &http.Server{Handler: mux}
+	go s.server.Serve(l)
+	return s, nil
+}
+
+// SnapshotURL returns the URL that serves a single image.
+func (s *ImageServer) SnapshotURL() string {
+	return s.URL + "/image.jpg"
+}
+
+// StreamURL returns the URL that serves the MJPEG stream.
+func (s *ImageServer) StreamURL() string {
+	return s.URL + "/stream.mjpeg"
+}
+
+// SetInterval sets the interval between the frames of a stream.
+func (s *ImageServer) SetInterval(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval = d
+}
+
+// SetStreamLimit sets the number of frames sent before a stream is
+// closed (0 for no limit).
+func (s *ImageServer) SetStreamLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = n
+}
+
+// SetDelay sets a delay before each response is sent.
+func (s *ImageServer) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Fail causes the next n requests to fail with a server error.
+func (s *ImageServer) Fail(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = n
+}
+
+// Requests returns the number of requests received.
+func (s *ImageServer) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Close shuts down the server, closing any open streams.
+func (s *ImageServer) Close() error {
+	return s.server.Close()
+}
+
+// Count the request, and return the delay before responding, or
+// false if the request should fail.
+func (s *ImageServer) request() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.fail > 0 {
+		s.fail--
+		return s.delay, false
+	}
+	return s.delay, true
+}
+
+// Return the next frame in turn.
+func (s *ImageServer) frame() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.frames[s.next]
+	s.next = (s.next + 1) % len(s.frames)
+	return f
+}
+
+// Pause for the delay, returning false if the request was cancelled.
+func pause(req *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-req.Context().Done():
+		return false
+	}
+}
+
+func (s *ImageServer) snapshot(w http.ResponseWriter, req *http.Request) {
+	d, ok := s.request()
+	if !pause(req, d) {
+		return
+	}
+	if !ok {
+		http.Error(w, "Failure requested", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(s.frame())
+}
+
+func (s *ImageServer) stream(w http.ResponseWriter, req *http.Request) {
+	d, ok := s.request()
+	if !pause(req, d) {
+		return
+	}
+	if !ok {
+		http.Error(w, "Failure requested", http.StatusServiceUnavailable)
+		return
+	}
+	s.mu.Lock()
+	interval, limit := s.interval, s.limit
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+imageServerBoundary)
+	flusher, _ := w.(http.Flusher)
+	for n := 0; limit == 0 || n < limit; n++ {
+		if n > 0 && !pause(req, interval) {
+			return
+		}
+		f := s.frame()
+		fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", imageServerBoundary, len(f))
+		if _, err := w.Write(f); err != nil {
+			return
+		}
+		if _, err := w.Write([]byte("\r\n")); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	fmt.Fprintf(w, "--%s--\r\n", imageServerBoundary)
+}
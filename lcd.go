@@ -19,7 +19,9 @@ package lcd
 
 import (
 	"fmt"
+	"image"
 	"math/rand"
+	"strings"
 	"time"
 )
 
@@ -27,6 +29,14 @@ import (
 const offMargin = 5
 const onMargin = 2
 
+// Limits on the size of digits and the co-ordinates of points. Configurations outside
+// these limits are rejected, since they would use excessive memory or time.
+const maxDigitSize = 1000
+const maxCoord = 100000
+
+// Limit on the number of points examined when creating the point lists of a template.
+const maxTemplatePoints = 500000
+
 // Segments, as enum and bit mask.
 const (
 	S_TL, M_TL = iota, 1 << iota // Top left
@@ -39,6 +49,9 @@ const (
 	SEGMENTS, _
 )
 
+// Names of the segments, indexed by segment enum.
+var segNames = [SEGMENTS]string{"TL", "TM", "TR", "BR", "BM", "BL", "MM"}
+
 // Base template for one type/size of 7-segment digit.
 // Points are all relative to the top left corner position.
 // When a digit is created using this template, the points are
@@ -46,6 +59,7 @@ const (
 // The idea is that different size of digits use a different
 // template, and that multiple digits can be created from a single template.
 type Template struct {
+	conf LcdTemplate       // Configuration used to create template
 	name string            // Name of template
 	line int               // Line width of segments
 	bb   BBox              // Bounding box of digit
@@ -66,7 +80,8 @@ type Template struct {
 // point values with the absolute point representing the top left of the digit.
 // All cordinates are absolute as a result.
 type Digit struct {
-	index int // Digit index
+	index int       // Digit index
+	lcd   *Template // Template used to create digit
 	bb    BBox
 	tmr   Point
 	tml   Point
@@ -89,10 +104,20 @@ type segment struct {
 // the digits in an image.
 type LcdDecoder struct {
 	// Configuration values and flags.
-	Threshold int  // Default on/off threshold
-	History   int  // Size of moving average history
-	MaxLevels int  // Maximum number of threshold levels
-	Inverse   bool // True if darker is off e.g a LED rather than LCD.
+	Threshold     int  // Default on/off threshold
+	History       int  // Size of moving average history
+	MaxLevels     int  // Maximum number of threshold levels
+	Inverse       bool // True if darker is off e.g a LED rather than LCD.
+	StrictRestore bool // Reject calibration saved with different digit geometry
+	Backups       int  // Number of backup calibration files kept by SaveToFile
+
+	// Automatic checkpointing of calibration. If Store is set, the
+	// calibration is saved to the store by Recalibrate when the interval has elapsed.
+	Store              CalibrationStore // Store for checkpoints
+	CheckpointInterval time.Duration    // Interval between checkpoints
+	LastCheckpoint     time.Time        // Time of last checkpoint
+	CheckpointErr      error            // Error from last checkpoint
+	CheckpointFailures int              // Count of failed checkpoints
 
 	Digits    []*Digit             // List of digits to decode
 	templates map[string]*Template // Templates used to create digits
@@ -118,6 +143,7 @@ func NewLcdDecoder() *LcdDecoder {
 	l.Threshold = 50  // Percentage threshold for on/off
 	l.History = 5     // Size of moving average cache
 	l.MaxLevels = 200 // Maximum size of threshold levels list
+	l.Backups = 2     // Number of calibration backup files
 	l.levelsMap = make(map[int][]*levels)
 	l.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
 	return l
@@ -135,7 +161,65 @@ func (l *LcdDecoder) AddTemplate(conf LcdTemplate) error {
 	if _, ok := l.templates[conf.Name]; ok {
 		return fmt.Errorf("Duplicate template entry: %s", conf.Name)
 	}
-	t := &Template{name: conf.Name, line: conf.Width}
+	if err := checkTemplate(conf); err != nil {
+		return fmt.Errorf("%s: %v", conf.Name, err)
+	}
+	t, err := newTemplate(conf)
+	if err != nil {
+		return fmt.Errorf("%s: %v", conf.Name, err)
+	}
+	// A segment with no points cannot be sampled.
+	for i := range t.seg {
+		if len(t.seg[i].points) == 0 {
+			return fmt.Errorf("%s: segment %s has no points (width %d too large or corners degenerate?)", conf.Name, segNames[i], conf.Width)
+		}
+	}
+	l.templates[t.name] = t
+	return nil
+}
+
+// Check that the template's points and segment width are within the limits.
+func checkTemplate(conf LcdTemplate) error {
+	if len(conf.Dp) != 0 && len(conf.Dp) != 2 {
+		return fmt.Errorf("decimal point must have 2 values (x, y), found %d", len(conf.Dp))
+	}
+	if conf.Width < 1 || conf.Width > maxDigitSize {
+		return fmt.Errorf("segment width %d out of range (1-%d)", conf.Width, maxDigitSize)
+	}
+	points := [][]int{conf.Tl[:], conf.Tr[:], conf.Br[:], conf.Bl[:], conf.Dp}
+	for _, p := range points {
+		for _, v := range p {
+			if v < -maxCoord || v > maxCoord {
+				return fmt.Errorf("co-ordinate %d out of range", v)
+			}
+		}
+	}
+	// Points are relative to the top left.
+	for _, p := range points[1:] {
+		for i, v := range p {
+			if v-conf.Tl[i] < -maxDigitSize || v-conf.Tl[i] > maxDigitSize {
+				return fmt.Errorf("digit size larger than %d", maxDigitSize)
+			}
+		}
+	}
+	// The segment width must be no more than half the smallest side of the digit.
+	// Sides of zero length are degenerate, and are reported by Validate.
+	bb := BBox{{conf.Tl[0], conf.Tl[1]}, {conf.Tr[0], conf.Tr[1]}, {conf.Br[0], conf.Br[1]}, {conf.Bl[0], conf.Bl[1]}}
+	side := maxDigitSize * 2
+	for i := range bb {
+		if s := length(bb[i], bb[(i+1)%len(bb)]); s != 0 {
+			side = min(side, s)
+		}
+	}
+	if conf.Width*2 > side {
+		return fmt.Errorf("width (%d) is larger than half the digit size (%d)", conf.Width, side)
+	}
+	return nil
+}
+
+// Create a new template from the configuration.
+func newTemplate(conf LcdTemplate) (*Template, error) {
+	t := &Template{conf: conf, name: conf.Name, line: conf.Width}
 	// Offset the points so top left is (0,0). The value of the top left
 	// point is left as (0,0).
 	t.bb[1] = Point{X: conf.Tr[0] - conf.Tl[0], Y: conf.Tr[1] - conf.Tl[1]}
@@ -157,8 +241,6 @@ func (l *LcdDecoder) AddTemplate(conf LcdTemplate) error {
 	// upper and lower squares of the segments.
 	offbb1 := BBox{t.bb[TL], t.bb[TR], t.bmr, t.bml}.Inner(t.line + offMargin)
 	offbb2 := BBox{t.tml, t.tmr, t.bb[BR], t.bb[BL]}.Inner(t.line + offMargin)
-	t.off = offbb1.Points()
-	t.off = append(t.off, offbb2.Points()...)
 	// Create the bounding boxes of each segment in the digit.
 	// The assignments must match the bit allocation in the lookup table.
 	t.seg[S_TL].bb = SegmentBB(t.bb[TL], t.ml, t.bb[TR], t.mr, t.line, onMargin)
@@ -168,12 +250,21 @@ func (l *LcdDecoder) AddTemplate(conf LcdTemplate) error {
 	t.seg[S_BM].bb = SegmentBB(t.bb[BL], t.bb[BR], t.ml, t.mr, t.line, onMargin)
 	t.seg[S_BL].bb = SegmentBB(t.ml, t.bb[BL], t.mr, t.bb[BR], t.line, onMargin)
 	t.seg[S_MM].bb = SegmentBB(t.tml, t.tmr, t.bb[BL], t.bb[BR], t.line, onMargin)
+	// Check the number of points to be examined before creating the point lists.
+	n := offbb1.span() + offbb2.span()
+	for i := range t.seg {
+		n += t.seg[i].bb.span()
+	}
+	if n > maxTemplatePoints {
+		return nil, fmt.Errorf("template too large (%d points, maximum %d)", n, maxTemplatePoints)
+	}
+	t.off = offbb1.Points()
+	t.off = append(t.off, offbb2.Points()...)
 	// For each segment, create a list of all the points within the segment.
 	for i := range t.seg {
 		t.seg[i].points = t.seg[i].bb.Points()
 	}
-	l.templates[t.name] = t
-	return nil
+	return t, nil
 }
 
 // Add a digit using the named template. The template points are offset
@@ -185,9 +276,13 @@ func (l *LcdDecoder) AddDigit(conf DigitConfig) (*Digit, error) {
 	}
 	x := conf.Coord[0]
 	y := conf.Coord[1]
+	if x < -maxCoord || x > maxCoord || y < -maxCoord || y > maxCoord {
+		return nil, fmt.Errorf("co-ordinate (%d, %d) out of range", x, y)
+	}
 	index := len(l.Digits)
 	d := &Digit{}
 	d.index = index
+	d.lcd = t
 	d.bb = t.bb.Offset(x, y)
 	d.off = t.off.Offset(x, y)
 	d.dp = t.dp.Offset(x, y)
@@ -205,3 +300,56 @@ func (l *LcdDecoder) AddDigit(conf DigitConfig) (*Digit, error) {
 	l.Digits = append(l.Digits, d)
 	return d, nil
 }
+
+// BoundsError is returned when one or more digits do not fit within
+// the image bounds.
+type BoundsError struct {
+	Bounds  image.Rectangle // Bounds that were checked
+	Outside []string        // Descriptions of the digit elements outside the bounds
+}
+
+func (e *BoundsError) Error() string {
+	return fmt.Sprintf("Digits outside image bounds %v: %s", e.Bounds, strings.Join(e.Outside, ", "))
+}
+
+// CheckBounds verifies that all the points sampled for each digit lie within
+// an image of the size of r. The digit co-ordinates are relative to the
+// top left of the image, so only the size of r is used, allowing
+// images with a non-zero origin (such as sub-images) to be checked.
+// If any elements of a digit are outside the image, a *BoundsError is returned
+// listing the digits and segments affected.
+func (l *LcdDecoder) CheckBounds(r image.Rectangle) error {
+	size := image.Rect(0, 0, r.Dx(), r.Dy())
+	var outside []string
+	for _, d := range l.Digits {
+		if !inBounds(size, d.bb[:]) {
+			outside = append(outside, fmt.Sprintf("digit %d", d.index))
+			// Report the individual segments that are outside.
+			for i := range d.seg {
+				if !inBounds(size, d.seg[i].points) {
+					outside = append(outside, fmt.Sprintf("digit %d segment %s", d.index, segNames[i]))
+				}
+			}
+		}
+		if !inBounds(size, d.off) {
+			outside = append(outside, fmt.Sprintf("digit %d off region", d.index))
+		}
+		if !inBounds(size, d.dpb) {
+			outside = append(outside, fmt.Sprintf("digit %d decimal point", d.index))
+		}
+	}
+	if len(outside) != 0 {
+		return &BoundsError{Bounds: r, Outside: outside}
+	}
+	return nil
+}
+
+// inBounds returns true if all the points are within the rectangle.
+func inBounds(r image.Rectangle, pl PList) bool {
+	for _, p := range pl {
+		if !(image.Point{p.X, p.Y}).In(r) {
+			return false
+		}
+	}
+	return true
+}
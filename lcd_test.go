@@ -17,61 +17,396 @@ package lcd_test
 import (
 	"testing"
 
-	"fmt"
-	"image/jpeg"
-	"io/ioutil"
+	"flag"
+	"image"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/aamcrae/lcd"
-
-	"gopkg.in/yaml.v3"
 )
 
+var corpus = flag.String("corpus", "testdata", "Directory of configurations, manifests and images for TestImg")
+
+// TestImg runs the regression corpus. For each <name>.config in the corpus
+// directory, the manifest <name>.manifest lists the images and the expected decodes
+// (see lcd.ReadManifest). If a calibration file <name>.cal exists, it is restored
+// before the images are decoded.
+// To use an external corpus, run:
+//
+//	go test -run TestImg -args -corpus=<directory>
 func TestImg(t *testing.T) {
-	runTest(t, "test1", "12345678.", "12345678")
-	runTest(t, "test2", "12345678", "12345678")
-	runTest(t, "test3", "12345678", "12345678")
-	runTest(t, "test4", "12345678", "12345678")
-	runTest(t, "lcd6", "123.456", "123456")
-	runTest(t, "meter", "tot008765.4", "tot0087654")
+	configs, err := filepath.Glob(filepath.Join(*corpus, "*.config"))
+	if err != nil {
+		t.Fatalf("%s: %v", *corpus, err)
+	}
+	if len(configs) == 0 {
+		t.Fatalf("%s: no configurations found", *corpus)
+	}
+	var correct, total, images, failed int
+	for _, cname := range configs {
+		base := strings.TrimSuffix(cname, ".config")
+		m, err := lcd.LoadManifest(base + ".manifest")
+		if os.IsNotExist(err) {
+			t.Logf("%s: no manifest, skipped", cname)
+			continue
+		} else if err != nil {
+			t.Fatalf("%v", err)
+		}
+		c, t2, f := runCorpus(t, cname, base+".cal", m)
+		correct += c
+		total += t2
+		failed += f
+		images += len(m)
+	}
+	if total > 0 {
+		t.Logf("Corpus %s: %d images, %d failed, %d of %d digits correct (%d%%)", *corpus, images, failed, correct, total, correct*100/total)
+	}
 }
 
-func runTest(t *testing.T, name, result, cal string) {
-	cname := name + ".config"
-	imagename := name + ".jpg"
-	s, err := ioutil.ReadFile(filepath.Join("testdata", cname))
+// Decode the images in the manifest using the configuration, and return
+// the count of correct digits, total digits and failed images.
+func runCorpus(t *testing.T, cname, calname string, m []lcd.ManifestEntry) (int, int, int) {
+	conf, err := lcd.LoadConfig(cname)
 	if err != nil {
-		t.Fatalf("Can't read config %s: %v", cname, err)
+		t.Fatalf("%v", err)
 	}
-	var conf lcd.LcdConfig
-	err = yaml.Unmarshal([]byte(s), &conf)
+	l, err := lcd.CreateLcdDecoder(conf.Config)
 	if err != nil {
-		t.Fatalf("config parse fail %s: %v", cname, err)
+		t.Fatalf("LCD config for %s failed %v", cname, err)
+	}
+	if _, err := os.Stat(calname); err == nil {
+		if _, err := l.RestoreFromFile(calname); err != nil {
+			t.Fatalf("%s: %v", calname, err)
+		}
 	}
-	lcd, err := lcd.CreateLcdDecoder(conf)
+	var correct, total, failed int
+	for _, e := range m {
+		img, err := lcd.ReadImage(e.Image)
+		if err != nil {
+			t.Errorf("%s: %v", e.Image, err)
+			failed++
+			continue
+		}
+		img = conf.Prepare(img)
+		if len(e.Preset) != 0 {
+			if err := l.Preset(img, e.Preset); err != nil {
+				t.Errorf("Calibration Error for %s: %v", e.Image, err)
+			}
+		}
+		res := l.Decode(img)
+		got := res.Marked()
+		c, n := lcd.CompareDigits(e.Expected, got)
+		correct += c
+		total += n
+		if n > 0 {
+			t.Logf("%s: %d of %d digits correct (%d%%)", e.Image, c, n, c*100/n)
+		}
+		if c != n {
+			failed++
+			for i := range res.Decodes {
+				if !res.Decodes[i].Valid {
+					t.Logf("%s: element %d not found, bits = 0x%02x", e.Image, i, res.Scans[i].Mask)
+				}
+			}
+			t.Errorf("For %s (%s), expected %s, found %s", e.Image, filepath.Base(cname), e.Expected, got)
+		}
+	}
+	return correct, total, failed
+}
+
+func TestSubImage(t *testing.T) {
+	conf := readConfig(t, "test1.config")
+	img := readImage(t, "test1.jpg")
+	// Crop the image, and offset the digits to match the new origin.
+	crop := image.Rect(100, 100, img.Bounds().Max.X, img.Bounds().Max.Y)
+	sub := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}).SubImage(crop)
+	conf.Offset[0] -= crop.Min.X
+	conf.Offset[1] -= crop.Min.Y
+	l, err := lcd.CreateLcdDecoder(conf)
 	if err != nil {
-		t.Fatalf("LCD config for %s failed %v", cname, err)
+		t.Fatalf("LCD config failed %v", err)
+	}
+	if err := l.CheckBounds(sub.Bounds()); err != nil {
+		t.Fatalf("CheckBounds: %v", err)
 	}
-	ifile, err := os.Open(filepath.Join("testdata", imagename))
+	if err := l.Preset(sub, "12345678"); err != nil {
+		t.Fatalf("Calibration error: %v", err)
+	}
+	if res := l.Decode(sub); res.Text != "12345678." {
+		t.Errorf("Sub-image decode, expected %s, found %s", "12345678.", res.Text)
+	}
+}
+
+func TestBounds(t *testing.T) {
+	conf := readConfig(t, "test1.config")
+	// Declare a frame size that clips the last digits.
+	conf.Size = [2]int{500, 480}
+	_, err := lcd.CreateLcdDecoder(conf)
+	if err == nil {
+		t.Fatalf("Expected bounds error, got none")
+	}
+	be, ok := err.(*lcd.BoundsError)
+	if !ok {
+		t.Fatalf("Expected *BoundsError, got %v", err)
+	}
+	if len(be.Outside) == 0 || !strings.HasPrefix(be.Outside[0], "digit 5") {
+		t.Errorf("Unexpected bounds report: %v", be.Outside)
+	}
+	conf.Size = [2]int{0, 0}
+	l, err := lcd.CreateLcdDecoder(conf)
 	if err != nil {
-		t.Fatalf("%s: %v", imagename, err)
+		t.Fatalf("LCD config failed %v", err)
+	}
+	if err := l.CheckBounds(image.Rect(0, 0, 640, 480)); err != nil {
+		t.Errorf("Unexpected bounds error: %v", err)
+	}
+	// Decoding an image that clips the last digits reports them as invalid.
+	img := readImage(t, "test1.jpg")
+	if err := l.Preset(img, "12345678"); err != nil {
+		t.Fatalf("Preset: %v", err)
+	}
+	small := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}).SubImage(image.Rect(0, 0, 500, 480))
+	res := l.Decode(small)
+	be, ok = res.Err.(*lcd.BoundsError)
+	if !ok {
+		t.Fatalf("Expected *BoundsError, got %v", res.Err)
+	}
+	if len(be.Outside) == 0 || be.Outside[0] != "digit 5" {
+		t.Errorf("Unexpected bounds report: %v", be.Outside)
 	}
-	img, err := jpeg.Decode(ifile)
+	if m := res.Marked(); res.Invalid != 3 || m != "12345XXX" {
+		t.Errorf("Decode of clipped image: got %q (%d invalid)", m, res.Invalid)
+	}
+	if res := l.Decode(img); res.Err != nil {
+		t.Errorf("Unexpected decode error: %v", res.Err)
+	}
+}
+
+func readConfig(t testing.TB, name string) lcd.LcdConfig {
+	conf, err := lcd.LoadConfig(filepath.Join("testdata", name))
 	if err != nil {
-		t.Fatalf("Can't decode %s: %v", imagename, err)
+		t.Fatalf("Can't read config %s: %v", name, err)
 	}
-	err = lcd.Preset(img, cal)
+	return conf.Config
+}
+
+func readImage(t testing.TB, name string) image.Image {
+	img, err := lcd.ReadImage(filepath.Join("testdata", name))
 	if err != nil {
-		t.Errorf("Calibration Error for %s: %v", imagename, err)
+		t.Fatalf("%s: %v", name, err)
+	}
+	return img
+}
+
+func TestValidate(t *testing.T) {
+	for _, name := range []string{"test1", "test2", "test3", "test4", "lcd6", "meter"} {
+		if f := lcd.ValidateConfig(readConfig(t, name+".config")); len(f) != 0 {
+			t.Errorf("%s: unexpected findings: %v", name, f)
+		}
+	}
+	conf := lcd.LcdConfig{
+		Lcd: []lcd.LcdTemplate{
+			{Name: "A", Tr: [2]int{30, 0}, Br: [2]int{30, 60}, Bl: [2]int{0, 60}, Width: 5},
+			{Name: "B", Tr: [2]int{30, 0}, Br: [2]int{30, 0}, Bl: [2]int{0, 0}, Width: 5},
+			{Name: "C", Tr: [2]int{30, 60}, Br: [2]int{30, 0}, Bl: [2]int{0, 60}, Width: 5},
+			{Name: "E", Tr: [2]int{30, 0}, Br: [2]int{30, 60}, Bl: [2]int{0, 60}, Width: 20},
+			{Name: "F", Tr: [2]int{1000, 0}, Br: [2]int{1000, 1000}, Bl: [2]int{0, 1000}, Width: 100},
+		},
+		Digit: []lcd.DigitConfig{
+			{Lcd: "A", Coord: [2]int{0, 0}},
+			{Lcd: "A", Coord: [2]int{10, 10}},
+			{Lcd: "D", Coord: [2]int{100, 100}},
+		},
 	}
-	res := lcd.Decode(img)
-	if res.Text != result {
-		for i := range res.Decodes {
-			if !res.Decodes[i].Valid {
-				fmt.Printf("Element %d not found, bits = 0x%02x\n", i, res.Scans[i].Mask)
+	f := lcd.ValidateConfig(conf)
+	expect := []string{
+		"digit 2: Unknown template D",
+		"lcd E: width (20) is larger than half the digit size (30)",
+		"lcd F: template too large (890933 points, maximum 500000)",
+		"lcd B: corners 1 and 2 are the same point",
+		"lcd B: corners are degenerate (zero area)",
+		"lcd C: outline is self-intersecting",
+		"digit 0: overlaps digit 1",
+	}
+	for _, e := range expect {
+		found := false
+		for _, s := range f {
+			if s == e {
+				found = true
+				break
 			}
 		}
-		t.Errorf("For test %s, expected %s, found %s", name, result, res.Text)
+		if !found {
+			t.Errorf("Missing finding %q in %v", e, f)
+		}
+	}
+	if _, err := lcd.CreateLcdDecoder(conf); err == nil {
+		t.Errorf("Expected CreateLcdDecoder to fail")
+	}
+	// Without a size, the digits can be checked against an image.
+	c1 := readConfig(t, "test1.config")
+	if f := lcd.ValidateConfigImage(c1, readImage(t, "test1.jpg")); len(f) != 0 {
+		t.Errorf("test1: unexpected findings: %v", f)
+	}
+	f = lcd.ValidateConfigImage(c1, image.NewGray(image.Rect(0, 0, 100, 100)))
+	if len(f) == 0 || !strings.HasSuffix(f[0], "is outside the image size 100x100") {
+		t.Errorf("Expected digits outside the image, got %v", f)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	y := `
+source: img.jpg
+rotate: 10
+crop: [10, 20, 300, 200]
+config:
+  inverse: true
+  lcd:
+    - name: A
+      tr: [20,0]
+      br: [20,40]
+      bl: [0,40]
+      width: 4
+  digit:
+    - lcd: A
+      coord: [10,10]
+`
+	c, err := lcd.ReadConfig(strings.NewReader(y), false)
+	if err != nil {
+		t.Fatalf("ReadConfig: %v", err)
+	}
+	if c.Source != "img.jpg" || c.Rotate != 10 || len(c.Crop) != 4 || !c.Config.Inverse || len(c.Config.Lcd) != 1 || len(c.Config.Digit) != 1 {
+		t.Errorf("Unexpected config: %+v", c)
+	}
+	j := `{"config": {"lcd": [{"name": "A", "tr": [20,0], "br": [20,40], "bl": [0,40], "width": 4}], "digit": [{"lcd": "A", "coord": [10,10]}]}}`
+	c, err = lcd.ReadConfig(strings.NewReader(j), true)
+	if err != nil {
+		t.Fatalf("ReadConfig: %v", err)
+	}
+	if len(c.Config.Lcd) != 1 || c.Config.Digit[0].Coord[1] != 10 {
+		t.Errorf("Unexpected config: %+v", c)
+	}
+	// The older layout using a 'meter' key is accepted.
+	m := `
+meter:
+  source: img.jpg
+  rotate: 5
+  threshold: 40
+  lcd:
+    - name: A
+      tr: [20,0]
+      br: [20,40]
+      bl: [0,40]
+      width: 4
+  digit:
+    - lcd: A
+      coord: [10,10]
+`
+	c, err = lcd.ReadConfig(strings.NewReader(m), false)
+	if err != nil {
+		t.Fatalf("ReadConfig: %v", err)
+	}
+	if c.Source != "img.jpg" || c.Rotate != 5 || c.Config.Threshold != 40 || len(c.Config.Lcd) != 1 || len(c.Config.Digit) != 1 {
+		t.Errorf("Unexpected config: %+v", c)
+	}
+	// Unknown keys are rejected.
+	if _, err := lcd.ReadConfig(strings.NewReader("sauce: img.jpg\nconfig:\n  threshold: 40\n"), false); err == nil {
+		t.Errorf("Expected error for unknown key")
+	}
+	if _, err := lcd.ReadConfig(strings.NewReader("lcd: []\nthreshhold: 40\n"), false); err == nil {
+		t.Errorf("Expected error for unknown key")
+	}
+	if _, err := lcd.ReadConfig(strings.NewReader("meter:\n  crop: [0,0,10,10]\n"), false); err == nil {
+		t.Errorf("Expected error for unknown key")
+	}
+	if _, err := lcd.ReadConfig(strings.NewReader(`{"config": {"lcds": []}}`), true); err == nil {
+		t.Errorf("Expected error for unknown key")
+	}
+}
+
+func TestManifest(t *testing.T) {
+	m, err := lcd.ReadManifest(strings.NewReader(`
+# Comment
+test1.jpg 12345678. 12345678
+meter.jpg   "tot0 8X65.4"
+"a b.jpg" "\"x\""
+`))
+	if err != nil {
+		t.Fatalf("ReadManifest: %v", err)
+	}
+	expect := []lcd.ManifestEntry{
+		{Image: "test1.jpg", Expected: "12345678.", Preset: "12345678"},
+		{Image: "meter.jpg", Expected: "tot0 8X65.4"},
+		{Image: "a b.jpg", Expected: `"x"`},
+	}
+	if len(m) != len(expect) {
+		t.Fatalf("Expected %d entries, got %d", len(expect), len(m))
+	}
+	var b strings.Builder
+	for i := range m {
+		if m[i] != expect[i] {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, expect[i], m[i])
+		}
+		lcd.WriteManifestEntry(&b, m[i])
+	}
+	// Check the entries can be read back.
+	m2, err := lcd.ReadManifest(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatalf("ReadManifest: %v", err)
+	}
+	for i := range m2 {
+		if m2[i] != m[i] {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, m[i], m2[i])
+		}
+	}
+	for _, bad := range []string{"test1.jpg\n", "a b c d\n", "a \"b\n"} {
+		if _, err := lcd.ReadManifest(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestCompareDigits(t *testing.T) {
+	for _, c := range []struct {
+		expected, got string
+		good, total   int
+	}{
+		{"12345678.", "12345678.", 8, 8},
+		{"12345678.", "12345678", 7, 8},
+		{"12X4", "1234", 3, 4},
+		{"123.45", "  123.45", 7, 7},
+		{"123.45", " 8123.45", 6, 7},
+		{"  123.45", "123.45", 0, 7},
+	} {
+		good, total := lcd.CompareDigits(c.expected, c.got)
+		if good != c.good || total != c.total {
+			t.Errorf("CompareDigits(%q, %q): expected %d of %d, got %d of %d", c.expected, c.got, c.good, c.total, good, total)
+		}
+	}
+}
+
+func TestMarked(t *testing.T) {
+	l, err := lcd.CreateLcdDecoder(readConfig(t, "test1.config"))
+	if err != nil {
+		t.Fatalf("LCD config failed %v", err)
+	}
+	img := readImage(t, "test1.jpg")
+	l.Preset(img, "12345678")
+	res := l.Decode(img)
+	if m := res.Marked(); m != "12345678." {
+		t.Errorf("Expected 12345678., got %s", m)
+	}
+	if res.Decodes[0].Confidence < 50 || res.Confidence > res.Decodes[0].Confidence {
+		t.Errorf("Unexpected confidence %d (digit 0: %d)", res.Confidence, res.Decodes[0].Confidence)
+	}
+	// An image of a different display has invalid digits.
+	res = l.Decode(readImage(t, "test4.jpg"))
+	if res.Invalid == 0 || strings.Count(res.Marked(), "X") != res.Invalid {
+		t.Errorf("Expected %d invalid digits marked, got %s", res.Invalid, res.Marked())
 	}
 }
@@ -19,9 +19,10 @@ import (
 	"fmt"
 	"image"
 	"io"
-	"os"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
 // levels contains the on/off thresholds for the individual segments.
@@ -59,6 +60,7 @@ type levels struct {
 	bad     int          // Count of undecodeable scans
 	good    int          // Count of successful scans
 	quality int          // quality metric 0-100
+	updated time.Time    // Time quality was last updated
 	digits  []*digLevels // List of levels for each digit
 }
 
@@ -119,6 +121,9 @@ func (l *LcdDecoder) CalibrateUsingScan(img image.Image, scans []*DigitScan) err
 	if len(scans) != len(l.Digits) {
 		return fmt.Errorf("Digit count mismatch (digits: %d, calibration: %d", len(scans), len(l.Digits))
 	}
+	if l.curLevels == nil {
+		l.curLevels = l.newLevels()
+	}
 	var default_on int
 	// If any digit has all segments off, we need to calculate an average max by
 	// averaging the on segments for all the (other) digits.
@@ -150,21 +155,54 @@ func (l *LcdDecoder) CalibrateUsingScan(img image.Image, scans []*DigitScan) err
 }
 
 // Restore the calibration data from a file.
+// If the file cannot be read or is invalid, the backup files
+// (see SaveToFile) are tried in order, newest first. The error
+// from the primary file is returned if no valid file is found.
 func (l *LcdDecoder) RestoreFromFile(f string) (int, error) {
-	if of, err := os.Open(f); err != nil {
-		return 0, err
+	return l.RestoreFromStore(&FileStore{Name: f, Backups: l.Backups})
+}
+
+// Restore the calibration data from a saved cache.
+// The data may either be in the current JSON format (as written by Save),
+// or in the legacy CSV format.
+// The number of calibration levels restored is returned.
+func (l *LcdDecoder) Restore(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	var calList []*levels
+	var err error
+	if firstNonSpace(br) == '{' {
+		calList, err = l.restoreJSON(br)
 	} else {
-		defer of.Close()
-		return l.Restore(of)
+		calList, err = l.restoreCSV(br)
+	}
+	if err != nil {
+		return len(calList), err
 	}
+	l.addRestored(calList)
+	return len(calList), nil
 }
 
-// Restore the calibration data from a saved cache.
+// Skip leading white space and return the next byte without consuming it.
+// 0 is returned if there is no more data.
+func firstNonSpace(r *bufio.Reader) byte {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0
+		}
+		if !unicode.IsSpace(rune(b)) {
+			r.UnreadByte()
+			return b
+		}
+	}
+}
+
+// Restore the calibration data from the legacy CSV format.
 // Format is a line of CSV, either:
 //
 //	index,quality
 //	index,digit,segment,min,max
-func (l *LcdDecoder) Restore(r io.Reader) (int, error) {
+func (l *LcdDecoder) restoreCSV(r io.Reader) ([]*levels, error) {
 	oldIndex := -1
 	scanner := bufio.NewScanner(r)
 	var cal *levels
@@ -176,16 +214,16 @@ func (l *LcdDecoder) Restore(r io.Reader) (int, error) {
 		tok := strings.Split(scanner.Text(), ",")
 		for _, s := range tok {
 			if val, err := strconv.ParseInt(s, 10, 32); err != nil {
-				return len(calList), fmt.Errorf("line %d, bad number (%v): %s", line, err, tok)
+				return calList, fmt.Errorf("line %d, bad number (%v): %s", line, err, tok)
 			} else {
 				v = append(v, int(val))
 			}
 		}
 		if len(v) != 2 && len(v) != 5 {
-			return len(calList), fmt.Errorf("line %d, illegal count of numbers (%d) - must be 2 or 5)", line, len(v))
+			return calList, fmt.Errorf("line %d, illegal count of numbers (%d) - must be 2 or 5)", line, len(v))
 		}
 		if v[0] < 0 || v[0] >= l.MaxLevels {
-			return len(calList), fmt.Errorf("line %d, index (%d) out of range - max %d", line, v[0], l.MaxLevels)
+			return calList, fmt.Errorf("line %d, index (%d) out of range - max %d", line, v[0], l.MaxLevels)
 		}
 		if v[0] != oldIndex {
 			cal = l.newLevels()
@@ -194,31 +232,31 @@ func (l *LcdDecoder) Restore(r io.Reader) (int, error) {
 			calList = append(calList, cal)
 		}
 		if len(v) == 2 {
+			if v[1] < 0 || v[1] > 100 {
+				return calList, fmt.Errorf("line %d, quality (%d) out of range", line, v[1])
+			}
 			cal.quality = v[1]
 		} else {
 			if v[1] < 0 || v[1] >= len(l.Digits) {
-				return len(calList), fmt.Errorf("line %d, out of range digit (%d)", line, v[1])
+				return calList, fmt.Errorf("line %d, out of range digit (%d)", line, v[1])
 			}
 			if v[2] < 0 || v[2] >= SEGMENTS {
-				return len(calList), fmt.Errorf("line %d, out of range segment (%d)", line, v[2])
+				return calList, fmt.Errorf("line %d, out of range segment (%d)", line, v[2])
 			}
 			s := &cal.digits[v[1]].segLevels[v[2]]
 			s.min.Init(v[3])
 			s.max.Init(v[4])
-			s.threshold = thresholdPercent(s.min.Value, s.max.Value, l.Threshold)
 		}
 	}
+	return calList, nil
+}
+
+// Add the restored calibration levels to the map, and pick a new
+// current calibration.
+func (l *LcdDecoder) addRestored(calList []*levels) {
 	for _, lv := range calList {
 		for _, d := range lv.digits {
-			var min, max int
-			for i := range d.segLevels {
-				min += d.segLevels[i].min.Value
-				max += d.segLevels[i].max.Value
-			}
-			// Keep the average of the min and max.
-			d.min = min / len(d.segLevels)
-			d.max = max / len(d.segLevels)
-			d.threshold = thresholdPercent(d.min, d.max, l.Threshold)
+			d.setThreshold(l.Threshold)
 		}
 	}
 	// Fill entire calibration list with saved entries.
@@ -233,45 +271,79 @@ func (l *LcdDecoder) Restore(r io.Reader) (int, error) {
 		}
 	}
 	l.PickCalibration()
-	return len(calList), nil
 }
 
-// Save the threshold data to a file.
-func (l *LcdDecoder) SaveToFile(f string, max int) error {
-	if of, err := os.Create(f); err != nil {
-		return err
-	} else {
-		defer of.Close()
-		return l.Save(of, max)
+// MigrateCalibration copies the calibration levels from the old decoder
+// for the digits that have the same index and an unchanged template in both decoders,
+// so that a change in configuration does not discard the existing calibration.
+// All the saved levels are migrated, as well as the current levels. Digits that
+// are not migrated are left uncalibrated. The moving averages are resized to the
+// new history size, and the thresholds of the migrated levels are recalculated
+// if the threshold percentage or history size has changed.
+// The list of migrated digit indices is returned, which is empty if the old
+// decoder has no calibration, or if the polarity (Inverse) has changed.
+func (l *LcdDecoder) MigrateCalibration(old *LcdDecoder) []int {
+	if old.curLevels == nil || l.Inverse != old.Inverse {
+		return nil
 	}
-}
-
-// Save the threshold data.
-// Only the highest quality level sets are saved.
-func (l *LcdDecoder) Save(w io.WriteCloser, max int) error {
-	written := 0
-	worst, best := l.qualRange()
-	for qual := best; qual >= worst; qual-- {
-		for _, lev := range l.levelsMap[qual] {
-			_, err := fmt.Fprintf(w, "%d,%d\n", written, lev.quality)
-			if err != nil {
-				return err
-			}
-			for i, d := range lev.digits {
-				for s := range d.segLevels {
-					_, err := fmt.Fprintf(w, "%d,%d,%d,%d,%d\n", written, i, s, d.segLevels[s].min.Value, d.segLevels[s].max.Value)
-					if err != nil {
-						return err
-					}
-				}
+	var migrated []int
+	for i, d := range l.Digits {
+		if i < len(old.Digits) && d.lcd.conf.Equal(old.Digits[i].lcd.conf) {
+			migrated = append(migrated, i)
+		}
+	}
+	if len(migrated) == 0 {
+		return nil
+	}
+	migrate := func(lev *levels) *levels {
+		nl := l.newLevels()
+		nl.quality = lev.quality
+		nl.good = lev.good
+		nl.bad = lev.bad
+		for _, i := range migrated {
+			d := lev.digits[i].copy()
+			for s := range d.segLevels {
+				d.segLevels[s].min = d.segLevels[s].min.resize(l.History)
+				d.segLevels[s].max = d.segLevels[s].max.resize(l.History)
 			}
-			written++
-			if written == max {
-				return nil
+			if l.Threshold != old.Threshold || l.History != old.History {
+				d.setThreshold(l.Threshold)
 			}
+			nl.digits[i] = d
 		}
+		return nl
 	}
-	return nil
+	l.levelsMap = make(map[int][]*levels)
+	l.Count = 0
+	l.Total = 0
+	for _, list := range old.levelsMap {
+		for _, lev := range list {
+			l.AddCalibration(migrate(lev))
+		}
+	}
+	// Drop the worst levels if there are more than the maximum.
+	for l.Count > l.MaxLevels {
+		w, _ := l.qualRange()
+		l.GetCalibration(w)
+	}
+	l.curLevels = migrate(old.curLevels)
+	l.Worst, l.Best = l.qualRange()
+	return migrated
+}
+
+// Save the threshold data to a file.
+// The data is written to a temporary file which is then renamed, so that
+// the file is never left partially written. Before the rename, the existing
+// file is kept as a backup (named with a suffix of .1, .2 etc.), up to the
+// number of backups set in Backups.
+func (l *LcdDecoder) SaveToFile(f string, max int) error {
+	return l.SaveToStore(&FileStore{Name: f, Backups: l.Backups}, max)
+}
+
+// Save the threshold data, in JSON format.
+// Only the highest quality level sets are saved, up to max sets (0 for no limit).
+func (l *LcdDecoder) Save(w io.WriteCloser, max int) error {
+	return l.saveJSON(w, max)
 }
 
 // Add a new calibration entry to the map
@@ -308,6 +380,9 @@ func (l *LcdDecoder) GetCalibration(qual int) (lev *levels) {
 // Return the digit decode error counters.
 func (l *LcdDecoder) DecodeErrors() []int {
 	var e []int
+	if l.curLevels == nil {
+		return e
+	}
 	for _, dig := range l.curLevels.digits {
 		e = append(e, dig.bad)
 	}
@@ -316,10 +391,16 @@ func (l *LcdDecoder) DecodeErrors() []int {
 
 // Save the current levels calibration in the map, discard the worst, and pick the best.
 func (l *LcdDecoder) Recalibrate() {
+	if l.curLevels == nil {
+		return
+	}
 	// Calculate a quality metric between 0-100 inclusive from
 	// the total number of good and bad scans.
-	t := l.curLevels.bad + l.curLevels.good
-	l.curLevels.quality = l.curLevels.good * 100 / t
+	// If there have been no scans, the quality is unchanged.
+	if t := l.curLevels.bad + l.curLevels.good; t > 0 {
+		l.curLevels.quality = l.curLevels.good * 100 / t
+	}
+	l.curLevels.updated = time.Now()
 	// Add the most recent threshold calibration back into the list.
 	l.AddCalibration(l.curLevels)
 	// If the map hasn't reached the maximum number, add a copy to
@@ -338,6 +419,7 @@ func (l *LcdDecoder) Recalibrate() {
 		}
 	}
 	l.PickCalibration()
+	l.checkpoint()
 }
 
 // Pick the best calibration from the list.
@@ -385,11 +467,17 @@ func (l *LcdDecoder) qualRange() (int, int) {
 
 // Record a successful decode.
 func (l *LcdDecoder) Good() {
+	if l.curLevels == nil {
+		l.curLevels = l.newLevels()
+	}
 	l.curLevels.good++
 }
 
 // Record an unsuccessful decode.
 func (l *LcdDecoder) Bad() {
+	if l.curLevels == nil {
+		l.curLevels = l.newLevels()
+	}
 	l.curLevels.bad++
 }
 
@@ -397,22 +485,30 @@ func (l *LcdDecoder) Bad() {
 func (l *levels) Copy() *levels {
 	nl := new(levels)
 	nl.quality = l.quality
+	nl.good = l.good
+	nl.bad = l.bad
+	nl.updated = l.updated
 	for _, d := range l.digits {
-		nd := new(digLevels)
-		nd.min = d.min
-		nd.max = d.max
-		nd.threshold = d.threshold
-		// Need to clone the moving averages.
-		for i := range nd.segLevels {
-			nd.segLevels[i].min = d.segLevels[i].min.Copy()
-			nd.segLevels[i].max = d.segLevels[i].max.Copy()
-			nd.segLevels[i].threshold = d.segLevels[i].threshold
-		}
-		nl.digits = append(nl.digits, nd)
+		nl.digits = append(nl.digits, d.copy())
 	}
 	return nl
 }
 
+// Copy the calibration levels of a digit.
+func (d *digLevels) copy() *digLevels {
+	nd := new(digLevels)
+	nd.min = d.min
+	nd.max = d.max
+	nd.threshold = d.threshold
+	// Need to clone the moving averages.
+	for i := range nd.segLevels {
+		nd.segLevels[i].min = d.segLevels[i].min.Copy()
+		nd.segLevels[i].max = d.segLevels[i].max.Copy()
+		nd.segLevels[i].threshold = d.segLevels[i].threshold
+	}
+	return nd
+}
+
 // Adjust and update the levels for this digit. The goal is to update
 // the moving average for the max and min values representing 'on' and 'off',
 // and recalculate the threshold from the updated values.
@@ -452,11 +548,18 @@ func (d *digLevels) adjustLevels(scan *DigitScan, default_off, default_on, thres
 			d.segLevels[i].max.SetDefault(default_on)
 		}
 	}
+	d.setThreshold(threshold)
+}
+
+// Calculate the average min and max of the segments, and the
+// thresholds of the digit and each segment.
+func (d *digLevels) setThreshold(threshold int) {
 	var max, min int
 	for i := range d.segLevels {
-		d.segLevels[i].threshold = thresholdPercent(d.segLevels[i].min.Value, d.segLevels[i].max.Value, threshold)
-		min += d.segLevels[i].min.Value
-		max += d.segLevels[i].max.Value
+		s := &d.segLevels[i]
+		s.threshold = thresholdPercent(s.min.Value, s.max.Value, threshold)
+		min += s.min.Value
+		max += s.max.Value
 	}
 	d.min = min / len(d.segLevels)
 	d.max = max / len(d.segLevels)
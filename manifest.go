@@ -0,0 +1,176 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ManifestEntry holds the expected decode of one labelled image.
+type ManifestEntry struct {
+	Image    string // Image file name
+	Expected string // Expected decode, in the form returned by DecodeResult.Marked
+	Preset   string // If set, the digits used to calibrate with the image before decoding
+}
+
+// ReadManifest reads a manifest of labelled images.
+// Each line of the manifest holds an image file name and the expected
+// decode of the image (as returned by DecodeResult.Marked, with 'X' for invalid digits
+// and '.' for decimal points), optionally followed by a string of digits used to
+// calibrate the decoder with the image (via Preset) before decoding.
+// Fields are separated by white space, and may be quoted (e.g if
+// the field contains blank digits). Blank lines and lines starting with '#' are ignored.
+//
+//	# image     expected    preset
+//	test1.jpg   12345678.   12345678
+//	meter.jpg   "  08765.4"
+func ReadManifest(r io.Reader) ([]ManifestEntry, error) {
+	var m []ManifestEntry
+	scanner := bufio.NewScanner(r)
+	var line int
+	for scanner.Scan() {
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if len(s) == 0 || s[0] == '#' {
+			continue
+		}
+		f, err := splitFields(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(f) < 2 || len(f) > 3 {
+			return nil, fmt.Errorf("line %d: expected 2 or 3 fields, found %d", line, len(f))
+		}
+		e := ManifestEntry{Image: f[0], Expected: f[1]}
+		if len(f) == 3 {
+			e.Preset = f[2]
+		}
+		m = append(m, e)
+	}
+	return m, scanner.Err()
+}
+
+// LoadManifest reads a manifest file. Relative image file names are
+// resolved relative to the directory of the manifest.
+func LoadManifest(name string) ([]ManifestEntry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := ReadManifest(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	dir := filepath.Dir(name)
+	for i := range m {
+		m[i].Image = resolvePath(dir, m[i].Image)
+	}
+	return m, nil
+}
+
+// WriteManifestEntry writes one manifest entry, quoting the fields if necessary.
+func WriteManifestEntry(w io.Writer, e ManifestEntry) error {
+	f := []string{quoteField(e.Image), quoteField(e.Expected)}
+	if len(e.Preset) != 0 {
+		f = append(f, quoteField(e.Preset))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(f, " "))
+	return err
+}
+
+// CompareDigits compares the expected and decoded digits (including any
+// decimal point), returning the number of digits that match and the number
+// of digits compared. If the expected value has fewer digits than the decode,
+// the missing leading digits are expected to be blank, e.g "12.5" matches "  12.5".
+func CompareDigits(expected, got string) (int, int) {
+	e := splitDigits(expected)
+	g := splitDigits(got)
+	for len(e) < len(g) {
+		e = append([]string{" "}, e...)
+	}
+	var c int
+	for i := range e {
+		if i < len(g) && e[i] == g[i] {
+			c++
+		}
+	}
+	return c, len(e)
+}
+
+// Split the decoded string into digits, each with an optional decimal point.
+func splitDigits(s string) []string {
+	var d []string
+	for i := 0; i < len(s); i++ {
+		if s[i] == '.' && len(d) > 0 {
+			d[len(d)-1] += "."
+		} else {
+			d = append(d, s[i:i+1])
+		}
+	}
+	return d
+}
+
+// Split the line into white space separated fields, allowing quoted fields.
+func splitFields(s string) ([]string, error) {
+	var f []string
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if len(s) == 0 {
+			return f, nil
+		}
+		if s[0] == '"' {
+			// Find the closing quote, skipping escaped characters.
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated quoted field: %s", s)
+			}
+			v, err := strconv.Unquote(s[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted field: %s", s[:end+1])
+			}
+			f = append(f, v)
+			s = s[end+1:]
+		} else {
+			end := strings.IndexFunc(s, unicode.IsSpace)
+			if end < 0 {
+				end = len(s)
+			}
+			f = append(f, s[:end])
+			s = s[end:]
+		}
+	}
+}
+
+// Quote the field if it is empty or contains white space or quotes.
+func quoteField(s string) string {
+	if len(s) == 0 || strings.IndexFunc(s, unicode.IsSpace) >= 0 || strings.ContainsAny(s, "\"#") {
+		return strconv.Quote(s)
+	}
+	return s
+}
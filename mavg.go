@@ -25,8 +25,11 @@ type Avg struct {
 }
 
 // Create a new moving average structure, size indicating the
-// number of historical values to be kept.
+// number of historical values to be kept (at least 1).
 func NewAvg(size int) *Avg {
+	if size < 1 {
+		size = 1
+	}
 	return &Avg{size: size}
 }
 
@@ -48,6 +51,16 @@ func (m *Avg) Copy() *Avg {
 	return na
 }
 
+// Create a copy of the moving average with a different history size,
+// keeping the most recent values.
+func (m *Avg) resize(size int) *Avg {
+	na := NewAvg(size)
+	for _, v := range m.history {
+		na.Add(v)
+	}
+	return na
+}
+
 // If not already initialised, init using this value.
 func (m *Avg) SetDefault(v int) {
 	if len(m.history) == 0 {
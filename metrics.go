@@ -0,0 +1,196 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds (in seconds) of the decode latency histogram buckets.
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Escapes the special characters of label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Metrics collects decoder health metrics, and exports them in the
+// Prometheus text format (e.g by serving Metrics as a HTTP handler).
+// Observe and Update are called by the goroutine using the decoder;
+// the metrics may be exported concurrently.
+type Metrics struct {
+	mu            sync.Mutex
+	labels        string    // Formatted constant labels
+	frames        int       // Frames decoded
+	invalidFrames int       // Frames with invalid digits
+	invalidDigits []int     // Invalid decodes of each digit
+	buckets       []int     // Decode latency histogram counts
+	latencyCount  int       // Count of decode latencies
+	latencySum    float64   // Sum of decode latencies
+	lastFrame     time.Time // Time of last frame
+	lastValid     time.Time // Time of last frame with all digits valid
+	saveFailures  int       // Calibration save failures reported by SaveFailed
+	calibration   *CalibrationSummary
+	total         int // Total quality of the calibration levels
+	checkpointErr int // Checkpoint failures of the decoder
+}
+
+// NewMetrics creates a metrics collector. The labels (e.g display="meter")
+// are added to every metric, so that several displays can be distinguished.
+func NewMetrics(labels map[string]string) *Metrics {
+	var names []string
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var l []string
+	for _, k := range names {
+		l = append(l, fmt.Sprintf("%s=\"%s\"", k, labelEscaper.Replace(labels[k])))
+	}
+	return &Metrics{labels: strings.Join(l, ","), buckets: make([]int, len(latencyBuckets))}
+}
+
+// Observe records the result of decoding a frame, and the time taken to decode it.
+func (m *Metrics) Observe(res *DecodeResult, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.frames++
+	m.lastFrame = now
+	if res.Invalid != 0 {
+		m.invalidFrames++
+	} else {
+		m.lastValid = now
+	}
+	for len(m.invalidDigits) < len(res.Decodes) {
+		m.invalidDigits = append(m.invalidDigits, 0)
+	}
+	for i, d := range res.Decodes {
+		if !d.Valid {
+			m.invalidDigits[i]++
+		}
+	}
+	s := latency.Seconds()
+	for i, b := range latencyBuckets {
+		if s <= b {
+			m.buckets[i]++
+		}
+	}
+	m.latencyCount++
+	m.latencySum += s
+}
+
+// Update records the calibration state of the decoder.
+func (m *Metrics) Update(l *LcdDecoder) {
+	sum := l.Summary()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calibration = sum
+	m.total = l.Total
+	m.checkpointErr = l.CheckpointFailures
+}
+
+// SaveFailed records a failure to save the calibration (other than
+// by checkpointing, which is recorded by Update).
+func (m *Metrics) SaveFailed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saveFailures++
+}
+
+// ServeHTTP writes the metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.Write(w)
+}
+
+// Write the metrics in the Prometheus text format.
+func (m *Metrics) Write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	value := func(name, labels string, v interface{}) {
+		if len(m.labels) != 0 {
+			if len(labels) != 0 {
+				labels = m.labels + "," + labels
+			} else {
+				labels = m.labels
+			}
+		}
+		if len(labels) != 0 {
+			fmt.Fprintf(bw, "%s{%s} %v\n", name, labels, v)
+		} else {
+			fmt.Fprintf(bw, "%s %v\n", name, v)
+		}
+	}
+	timestamp := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.UnixNano()) / 1e9
+	}
+	metric("lcd_frames_total", "counter", "Frames decoded.")
+	value("lcd_frames_total", "", m.frames)
+	metric("lcd_frames_invalid_total", "counter", "Frames decoded with one or more invalid digits.")
+	value("lcd_frames_invalid_total", "", m.invalidFrames)
+	metric("lcd_digit_invalid_total", "counter", "Invalid decodes of each digit position.")
+	for i, n := range m.invalidDigits {
+		value("lcd_digit_invalid_total", fmt.Sprintf("digit=\"%d\"", i), n)
+	}
+	metric("lcd_last_frame_timestamp_seconds", "gauge", "Time of the last frame decoded.")
+	value("lcd_last_frame_timestamp_seconds", "", timestamp(m.lastFrame))
+	metric("lcd_last_valid_timestamp_seconds", "gauge", "Time of the last frame decoded with all digits valid.")
+	value("lcd_last_valid_timestamp_seconds", "", timestamp(m.lastValid))
+	metric("lcd_decode_duration_seconds", "histogram", "Time taken to decode a frame.")
+	for i, b := range latencyBuckets {
+		value("lcd_decode_duration_seconds_bucket", fmt.Sprintf("le=\"%g\"", b), m.buckets[i])
+	}
+	value("lcd_decode_duration_seconds_bucket", "le=\"+Inf\"", m.latencyCount)
+	value("lcd_decode_duration_seconds_sum", "", m.latencySum)
+	value("lcd_decode_duration_seconds_count", "", m.latencyCount)
+	metric("lcd_calibration_save_failures_total", "counter", "Failures to save the calibration.")
+	value("lcd_calibration_save_failures_total", "", m.saveFailures+m.checkpointErr)
+	if c := m.calibration; c != nil {
+		gauges := []struct {
+			name, help string
+			v          int
+		}{
+			{"lcd_calibration_best", "Highest quality of the calibration levels.", c.Best},
+			{"lcd_calibration_worst", "Lowest quality of the calibration levels.", c.Worst},
+			{"lcd_calibration_last_quality", "Quality of the levels at the last recalibration.", c.LastQuality},
+			{"lcd_calibration_last_good", "Good decodes of the levels at the last recalibration.", c.LastGood},
+			{"lcd_calibration_last_bad", "Bad decodes of the levels at the last recalibration.", c.LastBad},
+			{"lcd_calibration_levels", "Count of calibration levels.", c.Count},
+			{"lcd_calibration_quality_total", "Total quality of the calibration levels.", m.total},
+		}
+		for _, g := range gauges {
+			metric(g.name, "gauge", g.help)
+			value(g.name, "", g.v)
+		}
+		metric("lcd_digit_decode_errors", "gauge", "Decode errors of each digit position since the last recalibration.")
+		for i, n := range c.DecodeErrors {
+			value("lcd_digit_decode_errors", fmt.Sprintf("digit=\"%d\"", i), n)
+		}
+	}
+	return bw.Flush()
+}
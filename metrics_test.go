@@ -0,0 +1,91 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd_test
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/aamcrae/lcd"
+)
+
+func TestMetrics(t *testing.T) {
+	conf := readConfig(t, "test1.config")
+	l := calibratedDecoder(t, conf)
+	m := lcd.NewMetrics(map[string]string{"display": "meter \"1\"", "camera": "a"})
+	good := l.Decode(readImage(t, "test1.jpg"))
+	m.Observe(good, 3*time.Millisecond)
+	// A different display has some invalid digits (digits 1 to 3
+	// are invalid, the rest are decoded as blank or 8).
+	bad := l.Decode(readImage(t, "lcd6.jpg"))
+	m.Observe(bad, 2*time.Second)
+	l.Good()
+	l.Bad()
+	l.Recalibrate()
+	m.Update(l)
+	m.SaveFailed()
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	res, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Unexpected content type %s", ct)
+	}
+	out := string(b)
+	lbl := `camera="a",display="meter \"1\""`
+	for _, want := range []string{
+		"# TYPE lcd_frames_total counter",
+		"lcd_frames_total{" + lbl + "} 2",
+		"lcd_frames_invalid_total{" + lbl + "} 1",
+		"lcd_digit_invalid_total{" + lbl + `,digit="0"} 0`,
+		"lcd_digit_invalid_total{" + lbl + `,digit="1"} 1`,
+		"lcd_digit_invalid_total{" + lbl + `,digit="4"} 0`,
+		"lcd_decode_duration_seconds_bucket{" + lbl + `,le="0.0025"} 0`,
+		"lcd_decode_duration_seconds_bucket{" + lbl + `,le="0.005"} 1`,
+		"lcd_decode_duration_seconds_bucket{" + lbl + `,le="1"} 1`,
+		"lcd_decode_duration_seconds_bucket{" + lbl + `,le="+Inf"} 2`,
+		"lcd_decode_duration_seconds_count{" + lbl + "} 2",
+		"lcd_decode_duration_seconds_sum{" + lbl + "} 2.003",
+		"lcd_calibration_save_failures_total{" + lbl + "} 1",
+		"lcd_calibration_last_quality{" + lbl + "} 50",
+		"lcd_calibration_last_good{" + lbl + "} 1",
+		"lcd_calibration_last_bad{" + lbl + "} 1",
+		"lcd_calibration_best{" + lbl + "} 100",
+		"lcd_calibration_levels{" + lbl + "} ",
+		"lcd_digit_decode_errors{" + lbl + `,digit="0"} 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Missing %q in:\n%s", want, out)
+		}
+	}
+	// Without labels, the metrics have no braces.
+	m = lcd.NewMetrics(nil)
+	m.Observe(good, time.Millisecond)
+	var sb strings.Builder
+	if err := m.Write(&sb); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !strings.Contains(sb.String(), "\nlcd_frames_total 1\n") {
+		t.Errorf("Unexpected metrics:\n%s", sb.String())
+	}
+}
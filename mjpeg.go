@@ -0,0 +1,374 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default limits for reading MJPEG streams.
+const (
+	defaultMJPEGTimeout    = 10 * time.Second
+	defaultMJPEGMinBackoff = time.Second
+	defaultMJPEGMaxBackoff = 30 * time.Second
+	defaultMaxFrameSize    = 16 << 20
+)
+
+// mjpegParser splits a multipart/x-mixed-replace stream into parts.
+// Cameras vary in how closely they follow the multipart format, so
+// the parser accepts boundaries with or without the leading dashes, parts
+// with or without a Content-Length header, and if the boundary is
+// not known, uses the first line starting with "--".
+type mjpegParser struct {
+	r        *bufio.Reader
+	boundary string // Boundary without leading dashes
+	maxSize  int    // Maximum part size
+	inPart   bool   // The boundary of the next part has been read
+	done     bool   // The closing boundary has been read
+}
+
+func newMJPEGParser(r io.Reader, boundary string, maxSize int) *mjpegParser {
+	return &mjpegParser{r: bufio.NewReader(r), boundary: strings.TrimLeft(boundary, "-"), maxSize: maxSize}
+}
+
+// Read a line, including the line terminator.
+func (p *mjpegParser) rawLine() ([]byte, error) {
+	var b []byte
+	for {
+		s, err := p.r.ReadSlice('\n')
+		b = append(b, s...)
+		if err == nil {
+			return b, nil
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		if len(b) > p.maxSize {
+			return nil, fmt.Errorf("line longer than %d bytes", p.maxSize)
+		}
+	}
+}
+
+// Read a line, with the line terminator removed.
+func (p *mjpegParser) line() (string, error) {
+	b, err := p.rawLine()
+	return strings.TrimRight(string(b), "\r\n"), err
+}
+
+// Check whether the line is a boundary, and whether it is the closing boundary.
+func (p *mjpegParser) isBoundary(l []byte) (bool, bool) {
+	l = bytes.TrimRight(l, "\r\n \t")
+	if !bytes.HasPrefix(l, []byte("--")) {
+		return false, false
+	}
+	l = bytes.TrimLeft(l, "-")
+	if len(p.boundary) == 0 {
+		// Use the first boundary seen.
+		p.boundary = string(l)
+		return len(l) > 0, false
+	}
+	if string(l) == p.boundary {
+		return true, false
+	}
+	if string(l) == p.boundary+"--" {
+		return true, true
+	}
+	return false, false
+}
+
+// next returns the data of the next part, or io.EOF at the end of the stream.
+func (p *mjpegParser) next() ([]byte, error) {
+	if p.done {
+		return nil, io.EOF
+	}
+	// Skip to the boundary.
+	for skipped := 0; !p.inPart; {
+		l, err := p.line()
+		if err != nil {
+			return nil, err
+		}
+		if ok, end := p.isBoundary([]byte(l)); ok {
+			if end {
+				p.done = true
+				return nil, io.EOF
+			}
+			break
+		}
+		if skipped += len(l); skipped > p.maxSize {
+			return nil, fmt.Errorf("no boundary found")
+		}
+	}
+	p.inPart = false
+	// Read the part headers.
+	size := -1
+	for {
+		l, err := p.line()
+		if err != nil {
+			return nil, err
+		}
+		if len(l) == 0 {
+			break
+		}
+		if i := strings.IndexByte(l, ':'); i > 0 && strings.EqualFold(strings.TrimSpace(l[:i]), "Content-Length") {
+			n, err := strconv.Atoi(strings.TrimSpace(l[i+1:]))
+			if err != nil || n < 0 || n > p.maxSize {
+				return nil, fmt.Errorf("bad content length %q", l[i+1:])
+			}
+			size = n
+		}
+	}
+	if size >= 0 {
+		b := make([]byte, size)
+		if _, err := io.ReadFull(p.r, b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	// No length, so read up to the next boundary.
+	var b []byte
+	for {
+		l, err := p.rawLine()
+		if err != nil {
+			return nil, err
+		}
+		if ok, end := p.isBoundary(l); ok {
+			p.inPart = true
+			p.done = end
+			break
+		}
+		if b = append(b, l...); len(b) > p.maxSize {
+			return nil, fmt.Errorf("part larger than %d bytes", p.maxSize)
+		}
+	}
+	// Remove the line terminator preceding the boundary.
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	b = bytes.TrimSuffix(b, []byte("\r"))
+	return b, nil
+}
+
+// nextImage returns the decoded image of the next part.
+func (p *mjpegParser) nextImage() (image.Image, error) {
+	b, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	return img, err
+}
+
+// Return a parser if the response is a multipart stream, otherwise nil.
+func mjpegResponse(res *http.Response, maxSize int) *mjpegParser {
+	mt, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mt, "multipart/") {
+		return nil
+	}
+	return newMJPEGParser(res.Body, params["boundary"], maxSize)
+}
+
+// MJPEGOptions are the options for a MJPEGReader. Zero values select the defaults.
+type MJPEGOptions struct {
+	Timeout      time.Duration // Timeout for connecting, and for reading each frame (default 10s)
+	MinBackoff   time.Duration // Delay before the first reconnection attempt (default 1s)
+	MaxBackoff   time.Duration // Maximum delay between reconnection attempts (default 30s)
+	MaxFrameSize int           // Maximum size of a frame in bytes (default 16MB)
+}
+
+// MJPEGReader reads a MJPEG stream in the background, sending the decoded
+// frames on the Frames channel. If the receiver falls behind, stale frames
+// are dropped so that the latest frame is always the one received.
+// If the stream fails, it is reopened after a delay that doubles
+// with each successive failure. The channel is closed when the reader is closed.
+type MJPEGReader struct {
+	URL    string
+	Frames <-chan *Frame // Decoded frames
+
+	opt     MJPEGOptions
+	frames  chan *Frame
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	client  http.Client
+	mu      sync.Mutex
+	err     error
+	dropped int
+	reopens int
+}
+
+// NewMJPEGReader starts reading the stream at the URL.
+func NewMJPEGReader(url string, opt MJPEGOptions) *MJPEGReader {
+	if opt.Timeout <= 0 {
+		opt.Timeout = defaultMJPEGTimeout
+	}
+	if opt.MinBackoff <= 0 {
+		opt.MinBackoff = defaultMJPEGMinBackoff
+	}
+	if opt.MaxBackoff <= 0 {
+		opt.MaxBackoff = defaultMJPEGMaxBackoff
+	}
+	if opt.MaxBackoff < opt.MinBackoff {
+		opt.MaxBackoff = opt.MinBackoff
+	}
+	if opt.MaxFrameSize <= 0 {
+		opt.MaxFrameSize = defaultMaxFrameSize
+	}
+	m := &MJPEGReader{URL: url, opt: opt, frames: make(chan *Frame, 1), done: make(chan struct{})}
+	m.Frames = m.frames
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	go m.run()
+	return m
+}
+
+// Next waits for the next frame, allowing the reader to be used as an ImageSource.
+// io.EOF is returned once the reader is closed.
+func (m *MJPEGReader) Next() (*Frame, error) {
+	if m.ctx.Err() != nil {
+		return nil, io.EOF
+	}
+	f, ok := <-m.frames
+	if !ok {
+		return nil, io.EOF
+	}
+	return f, nil
+}
+
+// Close stops the reader and waits for it to exit.
+func (m *MJPEGReader) Close() error {
+	m.cancel()
+	<-m.done
+	return nil
+}
+
+// Err returns the last stream error, or nil if the stream is
+// delivering frames.
+func (m *MJPEGReader) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Dropped returns the number of stale frames dropped.
+func (m *MJPEGReader) Dropped() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dropped
+}
+
+// Reopens returns the number of times the stream has been reopened after an error.
+func (m *MJPEGReader) Reopens() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reopens
+}
+
+// Read the stream until closed, reopening it after errors.
+func (m *MJPEGReader) run() {
+	defer close(m.done)
+	defer close(m.frames)
+	var backoff time.Duration
+	for {
+		n, err := m.stream()
+		if m.ctx.Err() != nil {
+			return
+		}
+		if n > 0 {
+			// Frames were read, so restart the backoff.
+			backoff = 0
+		}
+		if backoff == 0 {
+			backoff = m.opt.MinBackoff
+		} else if backoff *= 2; backoff > m.opt.MaxBackoff {
+			backoff = m.opt.MaxBackoff
+		}
+		m.mu.Lock()
+		m.err = err
+		m.reopens++
+		m.mu.Unlock()
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-m.ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// Open the stream and read frames until an error occurs, returning
+// the count of frames read.
+func (m *MJPEGReader) stream() (int, error) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+	timer := time.AfterFunc(m.opt.Timeout, cancel)
+	defer timer.Stop()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: %s", m.URL, res.Status)
+	}
+	p := mjpegResponse(res, m.opt.MaxFrameSize)
+	if p == nil {
+		return 0, fmt.Errorf("%s: not a MJPEG stream (%s)", m.URL, res.Header.Get("Content-Type"))
+	}
+	for n := 0; ; n++ {
+		timer.Reset(m.opt.Timeout)
+		img, err := p.nextImage()
+		if err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("%s: end of stream", m.URL)
+			}
+			return n, err
+		}
+		m.send(&Frame{Image: img, Time: time.Now(), Source: m.URL})
+	}
+}
+
+// Send the frame, replacing any frame that has not been received.
+func (m *MJPEGReader) send(f *Frame) {
+	m.mu.Lock()
+	m.err = nil
+	m.mu.Unlock()
+	for {
+		select {
+		case m.frames <- f:
+			return
+		default:
+		}
+		select {
+		case <-m.frames:
+			m.mu.Lock()
+			m.dropped++
+			m.mu.Unlock()
+		default:
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd_test
+
+import (
+	"testing"
+
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aamcrae/lcd"
+)
+
+// Build a stream of the test images in the style of various cameras.
+func mjpegStream(t *testing.T, style string, names ...string) string {
+	var b strings.Builder
+	b.WriteString("preamble\r\n")
+	for _, n := range names {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", n))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		switch style {
+		case "length":
+			fmt.Fprintf(&b, "--frame\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n%s\r\n", len(data), data)
+		case "nolength":
+			fmt.Fprintf(&b, "--frame\r\nContent-Type: image/jpeg\r\n\r\n%s\r\n", data)
+		case "lf":
+			fmt.Fprintf(&b, "--frame\ncontent-length: %d\n\n%s\n", len(data), data)
+		}
+	}
+	b.WriteString("--frame--\r\n")
+	return b.String()
+}
+
+func TestMJPEGFormats(t *testing.T) {
+	names := []string{"test1.jpg", "lcd6.jpg", "meter.jpg"}
+	tests := []struct {
+		style       string
+		contentType string
+	}{
+		{"length", "multipart/x-mixed-replace; boundary=frame"},
+		{"length", "multipart/x-mixed-replace; boundary=--frame"},
+		{"nolength", "multipart/x-mixed-replace; boundary=frame"},
+		{"nolength", "multipart/x-mixed-replace"},
+		{"lf", "multipart/x-mixed-replace;boundary=\"frame\""},
+	}
+	for _, tc := range tests {
+		body := mjpegStream(t, tc.style, names...)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", tc.contentType)
+			io.WriteString(w, body)
+		}))
+		src := lcd.NewMJPEGSource(ts.URL, 2*time.Second)
+		for _, n := range names {
+			f, err := src.Next()
+			if err != nil {
+				t.Fatalf("%s, %s: %s: %v", tc.style, tc.contentType, n, err)
+			}
+			if f.Image.Bounds().Size() != readImage(t, n).Bounds().Size() {
+				t.Errorf("%s, %s: %s: wrong image size %v", tc.style, tc.contentType, n, f.Image.Bounds())
+			}
+		}
+		if _, err := src.Next(); err == nil {
+			t.Errorf("%s, %s: expected error at end of stream", tc.style, tc.contentType)
+		}
+		src.Close()
+		ts.Close()
+	}
+}
+
+func TestMJPEGReader(t *testing.T) {
+	s := imageServer(t, "test1.jpg", "lcd6.jpg")
+	s.SetInterval(5 * time.Millisecond)
+	s.SetStreamLimit(20)
+	s.Fail(1)
+	r := lcd.NewMJPEGReader(s.StreamURL(), lcd.MJPEGOptions{Timeout: 2 * time.Second, MinBackoff: 10 * time.Millisecond})
+	var last time.Time
+	for i := 0; i < 3; i++ {
+		f, err := r.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if f.Image == nil || f.Source != s.StreamURL() || f.Time.Before(last) {
+			t.Errorf("Bad frame %+v", f)
+		}
+		last = f.Time
+	}
+	// Fall behind the stream.
+	for i := 0; r.Dropped() == 0; i++ {
+		if i > 500 {
+			t.Fatalf("Expected stale frames to be dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if f, err := r.Next(); err != nil || f.Time.Before(last) {
+		t.Errorf("Bad frame after drop: %v", err)
+	}
+	// The failed request and the end of the first stream cause the stream to be reopened.
+	for i := 0; r.Reopens() < 2; i++ {
+		if i > 200 {
+			t.Fatalf("Stream not reopened (%d reopens)", r.Reopens())
+		}
+		r.Next()
+	}
+	if _, err := r.Next(); err != nil {
+		t.Fatalf("Next after reopen: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Expected EOF after close, got %v", err)
+	}
+}
+
+func TestMJPEGReaderErrors(t *testing.T) {
+	s := imageServer(t, "test1.jpg")
+	// A snapshot is not a stream.
+	r := lcd.NewMJPEGReader(s.SnapshotURL(), lcd.MJPEGOptions{MinBackoff: 10 * time.Millisecond})
+	for i := 0; r.Err() == nil; i++ {
+		if i > 100 {
+			t.Fatalf("Expected error")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(r.Err().Error(), "not a MJPEG stream") {
+		t.Errorf("Unexpected error %v", r.Err())
+	}
+	r.Close()
+	// A stalled stream times out.
+	s.SetDelay(time.Second)
+	r = lcd.NewMJPEGReader(s.StreamURL(), lcd.MJPEGOptions{Timeout: 50 * time.Millisecond, MinBackoff: time.Hour})
+	for i := 0; r.Reopens() == 0; i++ {
+		if i > 100 {
+			t.Fatalf("Expected timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Close interrupts the backoff.
+	r.Close()
+}
@@ -0,0 +1,245 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Broker is an in-process stand-in for a MQTT broker, for testing publishers.
+// It accepts connections and records the messages published, but does not
+// support subscriptions.
+type Broker struct {
+	Addr     string // Address of the broker as host:port
+	Username string // If set, clients must connect with this user name and password
+	Password string
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	messages []*Message
+	retained map[string]*Message
+	conns    map[net.Conn]bool
+	dropAcks int // Count of PUBACKs to be dropped
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// NewBroker starts a broker on a local port.
+func NewBroker() (*Broker, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &Broker{Addr: l.Addr().String(), listener: l, retained: make(map[string]*Message), conns: make(map[net.Conn]bool)}
+	b.cond = sync.NewCond(&b.mu)
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// Messages returns the messages published, in order.
+func (b *Broker) Messages() []*Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*Message(nil), b.messages...)
+}
+
+// Retained returns the retained message of the topic, or nil if there is none.
+func (b *Broker) Retained(topic string) *Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.retained[topic]
+}
+
+// Wait waits until n messages have been published to the topic,
+// returning the messages, or an error if the timeout expires.
+func (b *Broker) Wait(topic string, n int, timeout time.Duration) ([]*Message, error) {
+	t := time.AfterFunc(timeout, func() {
+		b.mu.Lock()
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+	defer t.Stop()
+	end := time.Now().Add(timeout)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		var ml []*Message
+		for _, m := range b.messages {
+			if m.Topic == topic {
+				ml = append(ml, m)
+			}
+		}
+		if len(ml) >= n {
+			return ml, nil
+		}
+		if !time.Now().Before(end) {
+			return ml, fmt.Errorf("%s: %d messages received, waiting for %d", topic, len(ml), n)
+		}
+		b.cond.Wait()
+	}
+}
+
+// DropAcks causes the next n PUBACKs to not be sent, simulating lost acknowledgements.
+func (b *Broker) DropAcks(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dropAcks = n
+}
+
+// Disconnect closes the connections of all clients, simulating a
+// network failure (so the will messages of the clients are published).
+func (b *Broker) Disconnect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.conns {
+		c.Close()
+	}
+}
+
+// Close stops the broker.
+func (b *Broker) Close() error {
+	err := b.listener.Close()
+	b.Disconnect()
+	b.wg.Wait()
+	return err
+}
+
+// Accept connections until the listener is closed.
+func (b *Broker) accept() {
+	defer b.wg.Done()
+	for {
+		c, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns[c] = true
+		b.mu.Unlock()
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.serve(c)
+			b.mu.Lock()
+			delete(b.conns, c)
+			b.mu.Unlock()
+			c.Close()
+		}()
+	}
+}
+
+// Record a published message.
+func (b *Broker) publish(m *Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.messages = append(b.messages, m)
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m
+		}
+	}
+	b.cond.Broadcast()
+}
+
+// Return true if the PUBACK is to be dropped.
+func (b *Broker) dropAck() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dropAcks > 0 {
+		b.dropAcks--
+		return true
+	}
+	return false
+}
+
+// Serve a client connection.
+func (b *Broker) serve(c net.Conn) {
+	r := bufio.NewReader(c)
+	p, err := readPacket(r)
+	if err != nil || p.ptype != typeConnect {
+		return
+	}
+	id, will, rc := b.connect(p)
+	c.Write((&packet{ptype: typeConnack, body: []byte{0, rc}}).encode())
+	if rc != 0 {
+		return
+	}
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			break
+		}
+		switch p.ptype {
+		case typePublish:
+			m, pid, err := decodePublish(p)
+			if err != nil {
+				return
+			}
+			m.ClientID = id
+			b.publish(m)
+			if m.QoS > 0 && !b.dropAck() {
+				c.Write((&packet{ptype: typePuback, body: appendUint16(nil, pid)}).encode())
+			}
+		case typePingreq:
+			c.Write((&packet{ptype: typePingresp}).encode())
+		case typeDisconnect:
+			return
+		default:
+			return
+		}
+	}
+	// The client disconnected without a DISCONNECT.
+	if will != nil {
+		b.publish(will)
+	}
+}
+
+// Decode a CONNECT packet, returning the client id, will message
+// and the CONNACK return code.
+func (b *Broker) connect(p *packet) (string, *Message, byte) {
+	r := &reader{b: p.body}
+	if r.string() != "MQTT" || r.byte() != 4 {
+		return "", nil, 1
+	}
+	flags := r.byte()
+	r.uint16() // Keep alive
+	id := r.string()
+	var will *Message
+	if flags&flagWill != 0 {
+		will = &Message{QoS: (flags >> 3) & 3, Retain: flags&flagWillRetain != 0, ClientID: id}
+		will.Topic = r.string()
+		will.Payload = append([]byte(nil), r.bytes()...)
+	}
+	var user, pass string
+	if flags&flagUsername != 0 {
+		user = r.string()
+	}
+	if flags&flagPassword != 0 {
+		pass = r.string()
+	}
+	if r.err != nil {
+		return "", nil, 2
+	}
+	if len(b.Username) != 0 && (user != b.Username || pass != b.Password) {
+		return "", nil, 4
+	}
+	return id, will, 0
+}
@@ -0,0 +1,268 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mqtt publishes decoded readings to a MQTT broker.
+// It contains a minimal MQTT 3.1.1 client (publishing only, QoS 0 and 1),
+// a publisher of readings (with Home Assistant discovery), and a broker
+// stand-in for testing.
+package mqtt
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ClientOptions are the options for connecting to a broker.
+type ClientOptions struct {
+	Broker    string        // Address of broker as host:port
+	ClientID  string        // Client identifier
+	Username  string        // User name (optional)
+	Password  string        // Password (optional)
+	KeepAlive time.Duration // Interval between keep alive pings (0 for none)
+	Timeout   time.Duration // Timeout for connecting and acknowledgements (default 10s)
+	Will      *Message      // Message published by the broker if the client disconnects unexpectedly
+}
+
+// Client is a MQTT client connection that can publish messages.
+// Client is safe for concurrent use.
+type Client struct {
+	opt     ClientOptions
+	conn    net.Conn
+	wmu     sync.Mutex // Serialises writes
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan struct{} // Waiting for PUBACK
+	err     error                    // Error that closed the connection
+	done    chan struct{}            // Closed when the connection is closed
+	wg      sync.WaitGroup
+}
+
+// Dial connects to the broker.
+func Dial(opt ClientOptions) (*Client, error) {
+	if opt.Timeout <= 0 {
+		opt.Timeout = 10 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", opt.Broker, opt.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{opt: opt, conn: conn, pending: make(map[uint16]chan struct{}), done: make(chan struct{})}
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(opt.Timeout))
+	if err := c.write(c.connectPacket()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p, err := readPacket(r)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %v", opt.Broker, err)
+	}
+	if p.ptype != typeConnack || len(p.body) != 2 {
+		conn.Close()
+		return nil, fmt.Errorf("%s: unexpected packet type %d", opt.Broker, p.ptype)
+	}
+	if rc := p.body[1]; rc != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("%s: connection refused (%s)", opt.Broker, connackError(rc))
+	}
+	conn.SetDeadline(time.Time{})
+	c.wg.Add(1)
+	go c.reader(r)
+	if opt.KeepAlive > 0 {
+		c.wg.Add(1)
+		go c.pinger()
+	}
+	return c, nil
+}
+
+// Return a description of a CONNACK return code.
+func connackError(rc byte) string {
+	switch rc {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("return code %d", rc)
+}
+
+// Build the CONNECT packet.
+func (c *Client) connectPacket() *packet {
+	b := appendString(nil, "MQTT")
+	b = append(b, 4) // Protocol level 3.1.1
+	flags := byte(flagCleanSession)
+	if w := c.opt.Will; w != nil {
+		flags |= flagWill | w.QoS<<3
+		if w.Retain {
+			flags |= flagWillRetain
+		}
+	}
+	if len(c.opt.Username) != 0 {
+		flags |= flagUsername
+		if len(c.opt.Password) != 0 {
+			flags |= flagPassword
+		}
+	}
+	b = append(b, flags)
+	b = appendUint16(b, uint16(c.opt.KeepAlive/time.Second))
+	b = appendString(b, c.opt.ClientID)
+	if w := c.opt.Will; w != nil {
+		b = appendString(b, w.Topic)
+		b = appendString(b, string(w.Payload))
+	}
+	if flags&flagUsername != 0 {
+		b = appendString(b, c.opt.Username)
+	}
+	if flags&flagPassword != 0 {
+		b = appendString(b, c.opt.Password)
+	}
+	return &packet{ptype: typeConnect, body: b}
+}
+
+// Write a packet.
+func (c *Client) write(p *packet) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.opt.Timeout))
+	_, err := c.conn.Write(p.encode())
+	return err
+}
+
+// Read packets from the broker until the connection is closed.
+func (c *Client) reader(r *bufio.Reader) {
+	defer c.wg.Done()
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		switch p.ptype {
+		case typePuback:
+			rd := &reader{b: p.body}
+			id := rd.uint16()
+			c.mu.Lock()
+			if ch, ok := c.pending[id]; ok {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+		case typePingresp:
+		default:
+			c.fail(fmt.Errorf("unexpected packet type %d", p.ptype))
+			return
+		}
+	}
+}
+
+// Send keep alive pings until the connection is closed.
+func (c *Client) pinger() {
+	defer c.wg.Done()
+	t := time.NewTicker(c.opt.KeepAlive / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := c.write(&packet{ptype: typePingreq}); err != nil {
+				c.fail(err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Record the error and close the connection.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+	}
+}
+
+// Err returns the error that closed the connection, or nil if it is open.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Publish sends the message. For QoS 1, Publish waits for the
+// broker to acknowledge the message.
+func (c *Client) Publish(m *Message) error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	if m.QoS > 1 {
+		return fmt.Errorf("QoS %d not supported", m.QoS)
+	}
+	var id uint16
+	var ack chan struct{}
+	if m.QoS == 1 {
+		ack = make(chan struct{})
+		c.mu.Lock()
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		id = c.nextID
+		c.pending[id] = ack
+		c.mu.Unlock()
+	}
+	if err := c.write(publishPacket(m, id)); err != nil {
+		c.fail(err)
+		return err
+	}
+	if ack == nil {
+		return nil
+	}
+	t := time.NewTimer(c.opt.Timeout)
+	defer t.Stop()
+	select {
+	case <-ack:
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-t.C:
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("%s: no acknowledgement for %s", c.opt.Broker, m.Topic)
+	}
+}
+
+// Close disconnects from the broker.
+func (c *Client) Close() error {
+	var err error
+	if c.Err() == nil {
+		err = c.write(&packet{ptype: typeDisconnect})
+	}
+	c.fail(fmt.Errorf("connection closed"))
+	c.wg.Wait()
+	return err
+}
@@ -0,0 +1,272 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt_test
+
+import (
+	"testing"
+
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aamcrae/lcd"
+	"github.com/aamcrae/lcd/mqtt"
+)
+
+const wait = 5 * time.Second
+
+func newBroker(t *testing.T) *mqtt.Broker {
+	b, err := mqtt.NewBroker()
+	if err != nil {
+		t.Fatalf("NewBroker: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func reading(text string, invalid int) *lcd.Reading {
+	r := &lcd.Reading{Time: time.Now(), Text: text, Invalid: invalid}
+	for _, c := range strings.TrimSpace(text) {
+		r.Digits = append(r.Digits, lcd.ReadingDigit{Char: string(c), Valid: true, Confidence: 90})
+	}
+	if invalid != 0 {
+		r.Digits[0] = lcd.ReadingDigit{}
+	}
+	return r
+}
+
+func TestClient(t *testing.T) {
+	b := newBroker(t)
+	c, err := mqtt.Dial(mqtt.ClientOptions{Broker: b.Addr, ClientID: "c1", KeepAlive: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	for qos := byte(0); qos <= 1; qos++ {
+		if err := c.Publish(&mqtt.Message{Topic: "a/b", Payload: []byte("xyz"), QoS: qos, Retain: qos == 1}); err != nil {
+			t.Fatalf("Publish QoS %d: %v", qos, err)
+		}
+	}
+	ml, err := b.Wait("a/b", 2, wait)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for i, m := range ml {
+		if string(m.Payload) != "xyz" || m.QoS != byte(i) || m.Retain != (i == 1) || m.ClientID != "c1" {
+			t.Errorf("message %d: got %+v", i, m)
+		}
+	}
+	if m := b.Retained("a/b"); m == nil || string(m.Payload) != "xyz" {
+		t.Errorf("retained: got %v", m)
+	}
+	if err := c.Publish(&mqtt.Message{Topic: "a/b", QoS: 2}); err == nil {
+		t.Errorf("QoS 2 publish did not fail")
+	}
+	// A clean disconnect does not publish the will.
+	if err := c.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if err := c.Publish(&mqtt.Message{Topic: "a/b"}); err == nil {
+		t.Errorf("publish after close did not fail")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	b := newBroker(t)
+	b.Username = "user"
+	b.Password = "secret"
+	if _, err := mqtt.Dial(mqtt.ClientOptions{Broker: b.Addr, ClientID: "c1", Username: "user", Password: "wrong"}); err == nil {
+		t.Errorf("Dial with bad password did not fail")
+	}
+	c, err := mqtt.Dial(mqtt.ClientOptions{Broker: b.Addr, ClientID: "c1", Username: "user", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	c.Close()
+}
+
+func TestWill(t *testing.T) {
+	b := newBroker(t)
+	will := &mqtt.Message{Topic: "will", Payload: []byte("gone"), QoS: 1, Retain: true}
+	c, err := mqtt.Dial(mqtt.ClientOptions{Broker: b.Addr, ClientID: "c1", Will: will})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	b.Disconnect()
+	ml, err := b.Wait("will", 1, wait)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if m := ml[0]; string(m.Payload) != "gone" || m.QoS != 1 || !m.Retain {
+		t.Errorf("will: got %+v", m)
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	b := newBroker(t)
+	opt := mqtt.Options{
+		ClientOptions: mqtt.ClientOptions{Broker: b.Addr, ClientID: "meter 1"},
+		QoS:           1,
+		Retain:        true,
+		Discovery:     "homeassistant",
+		Name:          "Meter",
+		Unit:          "kWh",
+		DeviceClass:   "energy",
+	}
+	p, err := mqtt.NewPublisher(opt)
+	if err != nil {
+		t.Fatalf("NewPublisher: %v", err)
+	}
+	if m := b.Retained("lcd/meter 1/status"); m == nil || string(m.Payload) != mqtt.Online {
+		t.Errorf("status: got %v", m)
+	}
+	// Check the discovery payloads.
+	var cfg map[string]interface{}
+	m := b.Retained("homeassistant/sensor/meter_1/reading/config")
+	if m == nil {
+		t.Fatalf("no reading discovery config")
+	}
+	if err := json.Unmarshal(m.Payload, &cfg); err != nil {
+		t.Fatalf("reading config: %v", err)
+	}
+	for k, v := range map[string]string{
+		"state_topic":         "lcd/meter 1/state",
+		"availability_topic":  "lcd/meter 1/status",
+		"unique_id":           "meter_1_reading",
+		"unit_of_measurement": "kWh",
+		"device_class":        "energy",
+	} {
+		if cfg[k] != v {
+			t.Errorf("reading config %s: got %v, want %s", k, cfg[k], v)
+		}
+	}
+	m = b.Retained(p.DiscoveryTopic("quality"))
+	if m == nil {
+		t.Fatalf("no quality discovery config")
+	}
+	cfg = nil
+	if err := json.Unmarshal(m.Payload, &cfg); err != nil {
+		t.Fatalf("quality config: %v", err)
+	}
+	if cfg["state_topic"] != "lcd/meter 1/health" || cfg["value_template"] != "{{ value_json.last_quality }}" {
+		t.Errorf("quality config: got %v", cfg)
+	}
+	// Only valid readings are published, but the digits are always published.
+	if err := p.Publish(reading(" 12.5", 0)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := p.Publish(reading(" X2.5", 1)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	ml, err := b.Wait("lcd/meter 1/state", 1, wait)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(ml) != 1 || string(ml[0].Payload) != "12.5" || !ml[0].Retain || ml[0].QoS != 1 {
+		t.Errorf("state: got %+v", ml)
+	}
+	var r lcd.Reading
+	if err := json.Unmarshal(b.Retained("lcd/meter 1/reading").Payload, &r); err != nil || r.Text != " 12.5" {
+		t.Errorf("reading: got %+v (%v)", r, err)
+	}
+	ml, err = b.Wait("lcd/meter 1/digit/0", 2, wait)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var d lcd.ReadingDigit
+	if err := json.Unmarshal(ml[1].Payload, &d); err != nil || d.Valid {
+		t.Errorf("digit 0: got %+v (%v)", d, err)
+	}
+	if err := p.PublishHealth(&lcd.CalibrationSummary{LastQuality: 95, DecodeErrors: []int{0, 1}}); err != nil {
+		t.Fatalf("PublishHealth: %v", err)
+	}
+	var s lcd.CalibrationSummary
+	if err := json.Unmarshal(b.Retained("lcd/meter 1/health").Payload, &s); err != nil || s.LastQuality != 95 {
+		t.Errorf("health: got %+v (%v)", s, err)
+	}
+	// After a connection failure, the will is published and the publisher reconnects.
+	b.Disconnect()
+	if _, err := b.Wait("lcd/meter 1/status", 2, wait); err != nil {
+		t.Fatalf("%v", err)
+	}
+	end := time.Now().Add(wait)
+	for p.Publish(reading(" 13.0", 0)) != nil {
+		if time.Now().After(end) {
+			t.Fatalf("publisher did not reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m := b.Retained("lcd/meter 1/state"); m == nil || string(m.Payload) != "13.0" {
+		t.Errorf("state after reconnect: got %v", m)
+	}
+	if m := b.Retained("lcd/meter 1/status"); m == nil || string(m.Payload) != mqtt.Online {
+		t.Errorf("status after reconnect: got %v", m)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if m := b.Retained("lcd/meter 1/status"); m == nil || string(m.Payload) != mqtt.Offline {
+		t.Errorf("status after close: got %v", m)
+	}
+}
+
+func TestPublisherLostAck(t *testing.T) {
+	b := newBroker(t)
+	opt := mqtt.Options{
+		ClientOptions: mqtt.ClientOptions{Broker: b.Addr, ClientID: "meter", Timeout: 200 * time.Millisecond},
+		QoS:           1,
+		Discovery:     "homeassistant",
+	}
+	p, err := mqtt.NewPublisher(opt)
+	if err != nil {
+		t.Fatalf("NewPublisher: %v", err)
+	}
+	defer p.Close()
+	config := p.DiscoveryTopic("reading")
+	// Lose the connection, and the acknowledgement of the first discovery message when reconnecting.
+	b.Disconnect()
+	if _, err := b.Wait("lcd/meter/status", 2, wait); err != nil {
+		t.Fatalf("%v", err)
+	}
+	b.DropAcks(1)
+	end := time.Now().Add(wait)
+	for {
+		err := p.Publish(reading(" 12.5", 0))
+		if err == nil {
+			break
+		}
+		if time.Now().After(end) {
+			t.Fatalf("publisher did not reconnect: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The discovery and online messages are sent again after the lost acknowledgement.
+	ml, err := b.Wait(config, 3, wait)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(ml) != 3 {
+		t.Errorf("Expected 3 discovery messages, got %d", len(ml))
+	}
+	if m := b.Retained("lcd/meter/status"); m == nil || string(m.Payload) != mqtt.Online {
+		t.Errorf("status after reconnect: got %v", m)
+	}
+	if m := b.Retained("lcd/meter/state"); m != nil {
+		t.Errorf("state retained without Retain: got %v", m)
+	}
+	if ml, err := b.Wait("lcd/meter/state", 1, wait); err != nil || string(ml[0].Payload) != "12.5" {
+		t.Errorf("state: got %v (%v)", ml, err)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MQTT 3.1.1 packet types.
+const (
+	typeConnect    = 1
+	typeConnack    = 2
+	typePublish    = 3
+	typePuback     = 4
+	typePingreq    = 12
+	typePingresp   = 13
+	typeDisconnect = 14
+)
+
+// Connect flags.
+const (
+	flagCleanSession = 0x02
+	flagWill         = 0x04
+	flagWillRetain   = 0x20
+	flagPassword     = 0x40
+	flagUsername     = 0x80
+)
+
+// Maximum size of a packet accepted (the protocol limit is 256MB).
+const maxPacketSize = 1 << 20
+
+// packet is a MQTT control packet.
+type packet struct {
+	ptype byte   // Packet type
+	flags byte   // Flags of the fixed header
+	body  []byte // Variable header and payload
+}
+
+// Read a packet.
+func readPacket(r *bufio.Reader) (*packet, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	p := &packet{ptype: b >> 4, flags: b & 0x0F}
+	// Remaining length is encoded in up to 4 bytes, 7 bits per byte.
+	var size, shift int
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, fmt.Errorf("bad remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size |= int(b&0x7F) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if size > maxPacketSize {
+		return nil, fmt.Errorf("packet too large (%d bytes)", size)
+	}
+	p.body = make([]byte, size)
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Encode the packet.
+func (p *packet) encode() []byte {
+	b := []byte{p.ptype<<4 | p.flags}
+	n := len(p.body)
+	for {
+		d := byte(n & 0x7F)
+		n >>= 7
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, p.body...)
+}
+
+// Append a big endian 16 bit value.
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// Append a length prefixed string.
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// reader decodes the fields of a packet body.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) uint16() uint16 {
+	if len(r.b) < 2 {
+		r.err = fmt.Errorf("packet too short")
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.b)
+	r.b = r.b[2:]
+	return v
+}
+
+func (r *reader) byte() byte {
+	if len(r.b) < 1 {
+		r.err = fmt.Errorf("packet too short")
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := int(r.uint16())
+	if len(r.b) < n {
+		r.err = fmt.Errorf("packet too short")
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+// Message is a published message.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retain   bool
+	ClientID string // Client that published the message (set by Broker)
+}
+
+// Encode a PUBLISH packet.
+func publishPacket(m *Message, id uint16) *packet {
+	p := &packet{ptype: typePublish, flags: m.QoS << 1}
+	if m.Retain {
+		p.flags |= 1
+	}
+	p.body = appendString(nil, m.Topic)
+	if m.QoS > 0 {
+		p.body = appendUint16(p.body, id)
+	}
+	p.body = append(p.body, m.Payload...)
+	return p
+}
+
+// Decode a PUBLISH packet, returning the message and packet id.
+func decodePublish(p *packet) (*Message, uint16, error) {
+	r := &reader{b: p.body}
+	m := &Message{QoS: (p.flags >> 1) & 3, Retain: p.flags&1 != 0}
+	m.Topic = r.string()
+	var id uint16
+	if m.QoS > 0 {
+		id = r.uint16()
+	}
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if m.QoS > 2 {
+		return nil, 0, fmt.Errorf("bad QoS %d", m.QoS)
+	}
+	m.Payload = append([]byte(nil), r.b...)
+	return m, id, nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aamcrae/lcd"
+)
+
+// Payloads of the availability topic.
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// Options are the options for a Publisher. The topics used are:
+//
+//	<topic>/state       Text of valid readings (e.g "123.45")
+//	<topic>/reading     Valid readings as JSON (lcd.Reading)
+//	<topic>/digit/<n>   State of each digit as JSON (lcd.ReadingDigit)
+//	<topic>/health      Calibration summary as JSON (lcd.CalibrationSummary)
+//	<topic>/status      Availability ("online" or "offline")
+//
+// If Discovery is set, Home Assistant discovery payloads are published
+// (retained) on connection, for sensors of the reading and the calibration quality.
+type Options struct {
+	ClientOptions
+	Topic       string // Base topic (default "lcd/<client id>")
+	QoS         byte   // QoS of the messages (0 or 1)
+	Retain      bool   // Retain the state, reading, digit and health messages
+	Discovery   string // Home Assistant discovery prefix (e.g "homeassistant"), or empty for none
+	Name        string // Name of the device for discovery (default the client id)
+	Unit        string // Unit of measurement of the reading for discovery (optional)
+	DeviceClass string // Device class of the reading for discovery (optional, e.g "energy")
+}
+
+// Publisher publishes readings to a MQTT broker. If the connection fails,
+// it is reopened on the next publish. Publisher is not safe for concurrent use.
+type Publisher struct {
+	opt    Options
+	client *Client
+}
+
+// NewPublisher connects to the broker, and publishes the availability and
+// discovery messages.
+func NewPublisher(opt Options) (*Publisher, error) {
+	if len(opt.ClientID) == 0 {
+		return nil, fmt.Errorf("client id required")
+	}
+	if opt.QoS > 1 {
+		return nil, fmt.Errorf("QoS %d not supported", opt.QoS)
+	}
+	if len(opt.Topic) == 0 {
+		opt.Topic = "lcd/" + opt.ClientID
+	}
+	opt.Topic = strings.TrimSuffix(opt.Topic, "/")
+	if len(opt.Name) == 0 {
+		opt.Name = opt.ClientID
+	}
+	opt.Will = &Message{Topic: opt.Topic + "/status", Payload: []byte(Offline), QoS: opt.QoS, Retain: true}
+	p := &Publisher{opt: opt}
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Connect to the broker, if not already connected.
+func (p *Publisher) connect() error {
+	if p.client != nil {
+		if p.client.Err() == nil {
+			return nil
+		}
+		p.client.Close()
+		p.client = nil
+	}
+	c, err := Dial(p.opt.ClientOptions)
+	if err != nil {
+		return err
+	}
+	p.client = c
+	err = p.discovery()
+	if err == nil {
+		err = p.send("status", []byte(Online), true)
+	}
+	if err != nil {
+		// Close the connection, so that the discovery and status messages
+		// are sent again when reconnecting.
+		c.Close()
+		p.client = nil
+	}
+	return err
+}
+
+// Publish a message to the topic relative to the base topic.
+func (p *Publisher) send(topic string, payload []byte, retain bool) error {
+	return p.client.Publish(&Message{Topic: p.opt.Topic + "/" + topic, Payload: payload, QoS: p.opt.QoS, Retain: retain})
+}
+
+// Publish a value as JSON.
+func (p *Publisher) sendJSON(topic string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return p.send(topic, b, p.opt.Retain)
+}
+
+// Publish publishes the reading. The state of each digit is always
+// published, but the reading itself is only published if all digits are valid.
+func (p *Publisher) Publish(r *lcd.Reading) error {
+	if err := p.connect(); err != nil {
+		return err
+	}
+	if r.Invalid == 0 {
+		if err := p.send("state", []byte(strings.TrimSpace(r.Text)), p.opt.Retain); err != nil {
+			return err
+		}
+		if err := p.sendJSON("reading", r); err != nil {
+			return err
+		}
+	}
+	for i, d := range r.Digits {
+		if err := p.sendJSON(fmt.Sprintf("digit/%d", i), &d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishHealth publishes the calibration summary.
+func (p *Publisher) PublishHealth(s *lcd.CalibrationSummary) error {
+	if err := p.connect(); err != nil {
+		return err
+	}
+	return p.sendJSON("health", s)
+}
+
+// Close publishes the offline status, and disconnects.
+func (p *Publisher) Close() error {
+	if p.client == nil {
+		return nil
+	}
+	if p.client.Err() == nil {
+		p.send("status", []byte(Offline), true)
+	}
+	err := p.client.Close()
+	p.client = nil
+	return err
+}
+
+// discoveryConfig is a Home Assistant MQTT discovery payload.
+type discoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	StateTopic        string          `json:"state_topic"`
+	ValueTemplate     string          `json:"value_template,omitempty"`
+	Unit              string          `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	StateClass        string          `json:"state_class,omitempty"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	Device            discoveryDevice `json:"device"`
+}
+
+type discoveryDevice struct {
+	Identifiers []string `json:"identifiers"`
+	Name        string   `json:"name"`
+	Model       string   `json:"model"`
+}
+
+// DiscoveryTopic returns the Home Assistant discovery topic of the sensor.
+func (p *Publisher) DiscoveryTopic(sensor string) string {
+	return fmt.Sprintf("%s/sensor/%s/%s/config", p.opt.Discovery, nodeID(p.opt.ClientID), sensor)
+}
+
+// Return the client id as a discovery node id, which may only contain
+// letters, digits, underscore and hyphen.
+func nodeID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+// Publish the discovery payloads.
+func (p *Publisher) discovery() error {
+	if len(p.opt.Discovery) == 0 {
+		return nil
+	}
+	id := nodeID(p.opt.ClientID)
+	dev := discoveryDevice{Identifiers: []string{id}, Name: p.opt.Name, Model: "LCD decoder"}
+	avail := p.opt.Topic + "/status"
+	sensors := map[string]*discoveryConfig{
+		"reading": {
+			Name:        p.opt.Name,
+			StateTopic:  p.opt.Topic + "/state",
+			Unit:        p.opt.Unit,
+			DeviceClass: p.opt.DeviceClass,
+			StateClass:  "measurement",
+		},
+		"quality": {
+			Name:          p.opt.Name + " calibration quality",
+			StateTopic:    p.opt.Topic + "/health",
+			ValueTemplate: "{{ value_json.last_quality }}",
+			Unit:          "%",
+			StateClass:    "measurement",
+		},
+	}
+	for _, s := range []string{"reading", "quality"} {
+		c := sensors[s]
+		c.UniqueID = id + "_" + s
+		c.AvailabilityTopic = avail
+		c.Device = dev
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if err := p.client.Publish(&Message{Topic: p.DiscoveryTopic(s), Payload: b, QoS: p.opt.QoS, Retain: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -35,10 +35,20 @@ func Adjust(s, e Point, adj int) Point {
 	return Point{s.X + adj*x/length, s.Y + adj*y/length}
 }
 
+// Return the length of the line between the points, rounded to the nearest integer.
+func length(s, e Point) int {
+	x := e.X - s.X
+	y := e.Y - s.Y
+	return int(math.Round(math.Sqrt(float64(x*x) + float64(y*y))))
+}
+
 // Return a list of points that splits the line (identified by start and
 // end) into a number of sections e.g if 3 sections are requested, a list of 2 points
 // are returned, representing the points 1/3 and 2/3 along the line.
 func Split(start, end Point, sections int) PList {
+	if sections < 1 {
+		return nil
+	}
 	lx := end.X - start.X
 	ly := end.Y - start.Y
 	p := make(PList, sections-1)
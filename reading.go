@@ -0,0 +1,57 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"time"
+)
+
+// Reading is a timestamped summary of the decode of one image,
+// suitable for reporting or logging (e.g as JSON).
+type Reading struct {
+	Time       time.Time      `json:"time"`            // Time of the reading
+	Text       string         `json:"text"`            // Decoded string of digits
+	Marked     string         `json:"marked"`          // Decoded digits with invalid digits as 'X'
+	Invalid    int            `json:"invalid"`         // Count of invalid digits
+	Confidence int            `json:"confidence"`      // Lowest confidence of all the digits
+	Digits     []ReadingDigit `json:"digits"`          // Decode of each digit
+	Error      string         `json:"error,omitempty"` // Error (e.g digits outside the image), if any
+}
+
+// ReadingDigit is the decode of one digit of a reading.
+type ReadingDigit struct {
+	Char       string `json:"char"`       // Decoded character, or empty if invalid
+	Valid      bool   `json:"valid"`      // True if the decode was successful
+	DP         bool   `json:"dp"`         // True if the decimal point is set
+	Confidence int    `json:"confidence"` // Confidence (0-100) in the segment states
+}
+
+// NewReading creates a reading from the decode result, taken at time t.
+func NewReading(res *DecodeResult, t time.Time) *Reading {
+	r := &Reading{
+		Time:       t,
+		Text:       res.Text,
+		Marked:     res.Marked(),
+		Invalid:    res.Invalid,
+		Confidence: res.Confidence,
+	}
+	if res.Err != nil {
+		r.Error = res.Err.Error()
+	}
+	for _, d := range res.Decodes {
+		r.Digits = append(r.Digits, ReadingDigit{Char: d.Str, Valid: d.Valid, DP: d.DP, Confidence: d.Confidence})
+	}
+	return r
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd_test
+
+import (
+	"testing"
+
+	"encoding/json"
+	"time"
+
+	"github.com/aamcrae/lcd"
+)
+
+func TestReading(t *testing.T) {
+	l := calibratedDecoder(t, readConfig(t, "test1.config"))
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	r := lcd.NewReading(l.Decode(readImage(t, "test1.jpg")), now)
+	if r.Text != "12345678." || r.Marked != "12345678." || r.Invalid != 0 || !r.Time.Equal(now) {
+		t.Errorf("Unexpected reading %+v", r)
+	}
+	if len(r.Digits) != 8 {
+		t.Fatalf("Expected 8 digits, got %d", len(r.Digits))
+	}
+	for i, d := range r.Digits {
+		if !d.Valid || d.Char != string(rune('1'+i)) || d.DP != (i == 7) {
+			t.Errorf("Digit %d: unexpected decode %+v", i, d)
+		}
+		if d.Confidence < r.Confidence {
+			t.Errorf("Digit %d: confidence %d lower than reading confidence %d", i, d.Confidence, r.Confidence)
+		}
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var r2 lcd.Reading
+	if err := json.Unmarshal(b, &r2); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if r2.Text != r.Text || len(r2.Digits) != len(r.Digits) || !r2.Time.Equal(now) {
+		t.Errorf("JSON round trip: got %+v, want %+v", r2, r)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	l := calibratedDecoder(t, readConfig(t, "test1.config"))
+	s := l.Summary()
+	if s.Best != l.Best || s.Worst != l.Worst || s.Count != l.Count || s.LastQuality != l.LastQuality {
+		t.Errorf("Summary %+v does not match decoder", s)
+	}
+	if len(s.DecodeErrors) != len(l.Digits) {
+		t.Errorf("Expected %d decode error counts, got %v", len(l.Digits), s.DecodeErrors)
+	}
+}
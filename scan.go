@@ -15,6 +15,7 @@
 package lcd
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 )
@@ -28,19 +29,39 @@ type DigitScan struct {
 
 // DigitDecode is the result of decoding one digit in the image.
 type DigitDecode struct {
-	Char  byte   // The decoded character
-	Str   string // The decoded char as a string
-	Valid bool   // True if the decode was successful
-	DP    bool   // True if the decimal point is set
+	Char       byte   // The decoded character
+	Str        string // The decoded char as a string
+	Valid      bool   // True if the decode was successful
+	DP         bool   // True if the decimal point is set
+	Confidence int    // Confidence (0-100) in the on/off state of the segments
 }
 
 // DecodeResult contains the results of scanning and decoding one image.
 type DecodeResult struct {
-	Img     image.Image    // Image that has been scanned
-	Text    string         // Decoded string of digits
-	Invalid int            // Count of invalid digits
-	Scans   []*DigitScan   // Scan result
-	Decodes []*DigitDecode // List of decoded digits
+	Img        image.Image    // Image that has been scanned
+	Text       string         // Decoded string of digits
+	Invalid    int            // Count of invalid digits
+	Confidence int            // Lowest confidence of all the digits
+	Scans      []*DigitScan   // Scan result
+	Decodes    []*DigitDecode // List of decoded digits
+	Err        error          // If digits are outside the image, a *BoundsError
+}
+
+// Marked returns the decoded digits as a string, with invalid
+// digits shown as 'X', and decimal points included.
+func (r *DecodeResult) Marked() string {
+	var str []byte
+	for _, d := range r.Decodes {
+		if d.Valid {
+			str = append(str, d.Char)
+		} else {
+			str = append(str, 'X')
+		}
+		if d.DP {
+			str = append(str, '.')
+		}
+	}
+	return string(str)
 }
 
 // There are 128 possible values in a 7 segment digit, but only a subset
@@ -100,21 +121,46 @@ func init() {
 }
 
 // Decode the 7 segment digits in the image, and return a summary of the decoded values.
-// curLevels must be initialised either by having the levels restored from
+// curLevels should be initialised either by having the levels restored from
 // a file, or having been calibrated with an image via Preset.
+// Digits that are not entirely within the image are invalid, and are
+// reported in the result's Err.
 func (l *LcdDecoder) Decode(img image.Image) *DecodeResult {
+	if l.curLevels == nil {
+		l.curLevels = l.newLevels()
+	}
 	res := new(DecodeResult)
 	res.Img = img
 	res.Scans = l.Scan(img)
+	b := img.Bounds()
+	size := image.Rect(0, 0, b.Dx(), b.Dy())
+	var outside []string
 	var str []byte
+	res.Confidence = 100
 	for di, scan := range res.Scans {
 		decode := new(DigitDecode)
+		if d := l.Digits[di]; !inBounds(size, d.bb[:]) || !inBounds(size, d.dpb) {
+			// The digit cannot be read from this image.
+			outside = append(outside, fmt.Sprintf("digit %d", d.index))
+			res.Invalid++
+			res.Confidence = 0
+			res.Decodes = append(res.Decodes, decode)
+			continue
+		}
+		decode.Confidence = 100
 		// Check if sampled segment value is over threshold, and
 		// if so, set mask bit on.
 		for si, v := range scan.Segments {
-			if v >= l.curLevels.digits[di].segLevels[si].threshold {
+			sl := &l.curLevels.digits[di].segLevels[si]
+			if v >= sl.threshold {
 				scan.Mask |= 1 << uint(si)
 			}
+			if c := confidence(v, sl); c < decode.Confidence {
+				decode.Confidence = c
+			}
+		}
+		if decode.Confidence < res.Confidence {
+			res.Confidence = decode.Confidence
 		}
 		decode.Char, decode.Valid = resultTable[scan.Mask]
 		if decode.Valid {
@@ -132,9 +178,38 @@ func (l *LcdDecoder) Decode(img image.Image) *DecodeResult {
 		res.Decodes = append(res.Decodes, decode)
 	}
 	res.Text = string(str)
+	if len(res.Decodes) == 0 {
+		res.Confidence = 0
+	}
+	if len(outside) != 0 {
+		res.Err = &BoundsError{Bounds: b, Outside: outside}
+	}
 	return res
 }
 
+// confidence returns a value (0-100) indicating how far the sampled value is
+// from the segment's threshold, as a percentage of the distance from the threshold to
+// the calibrated min or max. A value at the threshold has a confidence of 0.
+func confidence(v int, sl *segLevels) int {
+	var r int
+	if v >= sl.threshold {
+		r = sl.max.Value - sl.threshold
+	} else {
+		r = sl.threshold - sl.min.Value
+	}
+	if r <= 0 {
+		return 0
+	}
+	c := (v - sl.threshold) * 100 / r
+	if c < 0 {
+		c = -c
+	}
+	if c > 100 {
+		c = 100
+	}
+	return c
+}
+
 // Scan samples the regions of the image that map to the segments of the digits,
 // and returns a list of the scanned digits.
 func (l *LcdDecoder) Scan(img image.Image) []*DigitScan {
@@ -155,22 +230,45 @@ func (l *LcdDecoder) Scan(img image.Image) []*DigitScan {
 	return scans
 }
 
+// SampleOff samples the 'off' region of each of the digits (the areas
+// inside the digit not covered by segments), and returns the list of values.
+func (l *LcdDecoder) SampleOff(img image.Image) []int {
+	var off []int
+	for _, d := range l.Digits {
+		off = append(off, l.sampleRegion(img, d.off))
+	}
+	return off
+}
+
 // Sample the points in the points list, and return a 16 bit value
 // representing the brightness level of the region.
 // Each point is converted to 16 bit grayscale and averaged across all the points in the list.
+// The points are relative to the origin of the image, so that images
+// with a non-zero origin (e.g sub-images) are sampled correctly. Points
+// that fall outside the image are ignored; if no points are inside the
+// image, 0 is returned.
 // The value is normalised so that higher values represent an 'on' state.
 func (l *LcdDecoder) sampleRegion(img image.Image, pl PList) int {
-	var gacc int
+	b := img.Bounds()
+	var gacc, count int
 	for _, s := range pl {
-		c := img.At(s.X, s.Y)
+		p := image.Point{s.X + b.Min.X, s.Y + b.Min.Y}
+		if !p.In(b) {
+			continue
+		}
+		c := img.At(p.X, p.Y)
 		pix := color.Gray16Model.Convert(c).(color.Gray16)
 		gacc += int(pix.Y)
+		count++
+	}
+	if count == 0 {
+		return 0
 	}
 	if l.Inverse {
 		// Lighter values are considered 'on' e.g when a LED image is scanned.
-		return gacc / len(pl)
+		return gacc / count
 	} else {
 		// Darker values are considered 'on' e.g when an LCD image is scanned.
-		return 0x10000 - gacc/len(pl)
+		return 0x10000 - gacc/count
 	}
 }
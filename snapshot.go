@@ -0,0 +1,122 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"time"
+)
+
+// Calibration is a read-only snapshot of one set of calibration levels,
+// used to display or compare calibrations.
+type Calibration struct {
+	Quality int                `json:"quality"`           // Quality metric 0-100
+	Good    int                `json:"good"`              // Count of successful scans
+	Bad     int                `json:"bad"`               // Count of undecodeable scans
+	Updated time.Time          `json:"updated,omitempty"` // Time quality was last updated
+	Digits  []DigitCalibration `json:"digits"`            // Levels of each digit
+}
+
+// DigitCalibration is a snapshot of the calibration levels of one digit.
+type DigitCalibration struct {
+	Min       int                          `json:"min"`       // Average min of all segments
+	Max       int                          `json:"max"`       // Average max of all segments
+	Threshold int                          `json:"threshold"` // Average threshold (used for the decimal point)
+	Bad       int                          `json:"bad"`       // Count of bad decodes
+	Segments  [SEGMENTS]SegmentCalibration `json:"segments"`  // Levels of each segment
+}
+
+// SegmentCalibration is a snapshot of the calibration levels of one segment.
+type SegmentCalibration struct {
+	Min        int   `json:"min"`         // Moving average of the 'off' value
+	Max        int   `json:"max"`         // Moving average of the 'on' value
+	Threshold  int   `json:"threshold"`   // On/off threshold
+	MinHistory []int `json:"min_history"` // Moving average history of the 'off' value
+	MaxHistory []int `json:"max_history"` // Moving average history of the 'on' value
+}
+
+// CalibrationSummary summarises the state of the calibration of a decoder.
+type CalibrationSummary struct {
+	Best         int   `json:"best"`          // Highest quality of the saved levels
+	Worst        int   `json:"worst"`         // Lowest quality of the saved levels
+	LastQuality  int   `json:"last_quality"`  // Quality of the last levels recalibrated
+	LastGood     int   `json:"last_good"`     // Count of good scans of the last levels
+	LastBad      int   `json:"last_bad"`      // Count of bad scans of the last levels
+	Count        int   `json:"count"`         // Count of saved levels
+	DecodeErrors []int `json:"decode_errors"` // Decode errors of each digit since the last recalibration
+}
+
+// Summary returns a summary of the calibration state.
+func (l *LcdDecoder) Summary() *CalibrationSummary {
+	return &CalibrationSummary{
+		Best:         l.Best,
+		Worst:        l.Worst,
+		LastQuality:  l.LastQuality,
+		LastGood:     l.LastGood,
+		LastBad:      l.LastBad,
+		Count:        l.Count,
+		DecodeErrors: l.DecodeErrors(),
+	}
+}
+
+// SegmentName returns the name of the segment (e.g "TL" for S_TL).
+func SegmentName(s int) string {
+	if s < 0 || s >= SEGMENTS {
+		return "?"
+	}
+	return segNames[s]
+}
+
+// Calibration returns a snapshot of the current calibration levels.
+// nil is returned if the decoder has not been calibrated.
+func (l *LcdDecoder) Calibration() *Calibration {
+	if l.curLevels == nil {
+		return nil
+	}
+	return l.curLevels.snapshot()
+}
+
+// Calibrations returns snapshots of the saved calibration levels,
+// ordered by quality, highest first. The current calibration levels
+// are not included.
+func (l *LcdDecoder) Calibrations() []*Calibration {
+	var cl []*Calibration
+	worst, best := l.qualRange()
+	for q := best; q >= worst; q-- {
+		for _, lev := range l.levelsMap[q] {
+			cl = append(cl, lev.snapshot())
+		}
+	}
+	return cl
+}
+
+// Create a snapshot of the levels.
+func (lev *levels) snapshot() *Calibration {
+	c := &Calibration{Quality: lev.quality, Good: lev.good, Bad: lev.bad, Updated: lev.updated}
+	for _, d := range lev.digits {
+		dc := DigitCalibration{Min: d.min, Max: d.max, Threshold: d.threshold, Bad: d.bad}
+		for i := range d.segLevels {
+			s := &d.segLevels[i]
+			dc.Segments[i] = SegmentCalibration{
+				Min:        s.min.Value,
+				Max:        s.max.Value,
+				Threshold:  s.threshold,
+				MinHistory: append([]int(nil), s.min.history...),
+				MaxHistory: append([]int(nil), s.max.history...),
+			}
+		}
+		c.Digits = append(c.Digits, dc)
+	}
+	return c
+}
@@ -0,0 +1,289 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Frame is an image read from a source.
+type Frame struct {
+	Image  image.Image // Image read
+	Time   time.Time   // Time the image was captured
+	Source string      // File name or URL the image was read from
+}
+
+// ImageSource is a source of images, such as a camera or a set of files.
+// Sources are not safe for concurrent use.
+type ImageSource interface {
+	// Next returns the next frame.
+	// io.EOF is returned when a source has no more frames.
+	Next() (*Frame, error)
+	// Close releases any resources held by the source.
+	Close() error
+}
+
+// OpenSource opens the source named, which may be:
+//   - a http or https URL, opened as a HTTPSource that fetches a snapshot
+//     for each frame
+//   - a directory, opened as a DirSource that repeats the images
+//   - an image file, opened as a FileSource
+//
+// The timeout is applied to each request to a URL.
+// MJPEG streams are not opened as a MJPEGSource, since frames buffered while the
+// caller is not reading would be stale; use a MJPEGReader to read a stream.
+func OpenSource(src string, timeout time.Duration) (ImageSource, error) {
+	if len(src) == 0 {
+		return nil, fmt.Errorf("no image source")
+	}
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return NewHTTPSource(src, timeout), nil
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return NewDirSource(src, true)
+	}
+	return NewFileSource(src), nil
+}
+
+// FileSource reads an image file, which is re-read for each frame
+// so that a file that is periodically replaced can be used as a source.
+type FileSource struct {
+	Name string
+}
+
+// NewFileSource creates a source that reads the image file.
+func NewFileSource(name string) *FileSource {
+	return &FileSource{Name: name}
+}
+
+// Next reads the file.
+func (f *FileSource) Next() (*Frame, error) {
+	img, err := ReadImage(f.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{Image: img, Time: modTime(f.Name), Source: f.Name}, nil
+}
+
+// Close is a no-op.
+func (f *FileSource) Close() error {
+	return nil
+}
+
+// DirSource replays the images in a directory in name order.
+// The capture time of each frame is the modification time of the file.
+type DirSource struct {
+	Files []string // Image files, in order
+	Loop  bool     // If set, restart from the first file after the last
+	index int
+}
+
+// NewDirSource creates a source of the image files (with a suffix of .jpg, .jpeg,
+// .png or .gif) in the directory.
+// If loop is set, the images are repeated, otherwise io.EOF is returned after the last image.
+func NewDirSource(dir string, loop bool) (*DirSource, error) {
+	ents, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	d := &DirSource{Loop: loop}
+	for _, e := range ents {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".jpg", ".jpeg", ".png", ".gif":
+			if !e.IsDir() {
+				d.Files = append(d.Files, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	if len(d.Files) == 0 {
+		return nil, fmt.Errorf("%s: no images in directory", dir)
+	}
+	sort.Strings(d.Files)
+	return d, nil
+}
+
+// Next reads the next image file.
+func (d *DirSource) Next() (*Frame, error) {
+	if d.index >= len(d.Files) {
+		if !d.Loop || len(d.Files) == 0 {
+			return nil, io.EOF
+		}
+		d.index = 0
+	}
+	f := d.Files[d.index]
+	d.index++
+	img, err := ReadImage(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f, err)
+	}
+	return &Frame{Image: img, Time: modTime(f), Source: f}, nil
+}
+
+// Close is a no-op.
+func (d *DirSource) Close() error {
+	return nil
+}
+
+// Return the modification time of the file, or the current time if
+// it cannot be read.
+func modTime(name string) time.Time {
+	if fi, err := os.Stat(name); err == nil {
+		return fi.ModTime()
+	}
+	return time.Now()
+}
+
+// HTTPSource fetches a snapshot image from a URL for each frame.
+// If the server returns a MJPEG stream, the first frame of the stream is used.
+type HTTPSource struct {
+	URL     string
+	Timeout time.Duration // Timeout for each request
+	client  http.Client
+}
+
+// NewHTTPSource creates a source that fetches snapshots from the URL.
+func NewHTTPSource(url string, timeout time.Duration) *HTTPSource {
+	h := &HTTPSource{URL: url, Timeout: timeout}
+	h.client.Timeout = timeout
+	return h
+}
+
+// Next fetches a snapshot.
+func (h *HTTPSource) Next() (*Frame, error) {
+	res, err := h.client.Get(h.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", h.URL, res.Status)
+	}
+	var img image.Image
+	if p := mjpegResponse(res, defaultMaxFrameSize); p != nil {
+		img, err = p.nextImage()
+	} else {
+		img, _, err = image.Decode(res.Body)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", h.URL, err)
+	}
+	return &Frame{Image: img, Time: time.Now(), Source: h.URL}, nil
+}
+
+// Close closes any idle connections.
+func (h *HTTPSource) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+// MJPEGSource reads frames from a MJPEG stream (a multipart/x-mixed-replace
+// response, as provided by many IP cameras). The stream is kept open, and
+// each frame read in turn. After an error, the stream is closed, and
+// reopened by the next call to Next.
+// If the server returns a single image rather than a stream, the image is
+// used and a new request made for each frame.
+type MJPEGSource struct {
+	URL     string
+	Timeout time.Duration // Timeout for each request or frame
+	client  http.Client
+	cancel  context.CancelFunc // Cancels the current request
+	timer   *time.Timer        // Cancels the request if the timeout expires
+	body    io.Closer
+	parts   *mjpegParser
+}
+
+// NewMJPEGSource creates a source that reads frames from the stream at the URL.
+func NewMJPEGSource(url string, timeout time.Duration) *MJPEGSource {
+	return &MJPEGSource{URL: url, Timeout: timeout}
+}
+
+// Next reads the next frame, opening the stream if required.
+func (m *MJPEGSource) Next() (*Frame, error) {
+	if m.parts == nil {
+		return m.open()
+	}
+	m.timer.Reset(m.Timeout)
+	img, err := m.parts.nextImage()
+	m.timer.Stop()
+	if err != nil {
+		m.Close()
+		return nil, fmt.Errorf("%s: stream: %v", m.URL, err)
+	}
+	return &Frame{Image: img, Time: time.Now(), Source: m.URL}, nil
+}
+
+// Request the URL. If a single image is returned, the frame is returned,
+// otherwise the stream is kept open and the first frame returned.
+func (m *MJPEGSource) open() (*Frame, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.URL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	m.cancel = cancel
+	m.timer = time.AfterFunc(m.Timeout, cancel)
+	res, err := m.client.Do(req)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	m.body = res.Body
+	if res.StatusCode != http.StatusOK {
+		m.Close()
+		return nil, fmt.Errorf("%s: %s", m.URL, res.Status)
+	}
+	if p := mjpegResponse(res, defaultMaxFrameSize); p != nil {
+		m.parts = p
+		m.timer.Stop()
+		return m.Next()
+	}
+	img, _, err := image.Decode(res.Body)
+	m.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", m.URL, err)
+	}
+	return &Frame{Image: img, Time: time.Now(), Source: m.URL}, nil
+}
+
+// Close closes the stream.
+func (m *MJPEGSource) Close() error {
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	var err error
+	if m.body != nil {
+		err = m.body.Close()
+	}
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.timer, m.body, m.cancel, m.parts = nil, nil, nil, nil
+	return err
+}
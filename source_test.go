@@ -0,0 +1,164 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd_test
+
+import (
+	"testing"
+
+	"image"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/aamcrae/lcd"
+)
+
+// Start an image server serving the test images.
+func imageServer(t *testing.T, names ...string) *lcd.ImageServer {
+	var images []image.Image
+	for _, n := range names {
+		images = append(images, readImage(t, n))
+	}
+	s, err := lcd.NewImageServer(images...)
+	if err != nil {
+		t.Fatalf("NewImageServer: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Read a frame, and check that it is the expected size.
+func nextFrame(t *testing.T, src lcd.ImageSource, want image.Rectangle) *lcd.Frame {
+	t.Helper()
+	f, err := src.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if f.Image.Bounds().Size() != want.Size() {
+		t.Errorf("%s: image size %v, want %v", f.Source, f.Image.Bounds().Size(), want.Size())
+	}
+	if f.Time.IsZero() {
+		t.Errorf("%s: no capture time", f.Source)
+	}
+	return f
+}
+
+func TestFileSource(t *testing.T) {
+	name := filepath.Join("testdata", "test1.jpg")
+	src, err := lcd.OpenSource(name, time.Second)
+	if err != nil {
+		t.Fatalf("OpenSource: %v", err)
+	}
+	defer src.Close()
+	want := readImage(t, "test1.jpg").Bounds()
+	for i := 0; i < 2; i++ {
+		if f := nextFrame(t, src, want); f.Source != name {
+			t.Errorf("Source %s, want %s", f.Source, name)
+		}
+	}
+	if _, err := lcd.OpenSource(filepath.Join("testdata", "missing.jpg"), time.Second); err == nil {
+		t.Errorf("Expected error for missing file")
+	}
+}
+
+func TestDirSource(t *testing.T) {
+	d, err := lcd.NewDirSource("testdata", false)
+	if err != nil {
+		t.Fatalf("NewDirSource: %v", err)
+	}
+	names, _ := filepath.Glob(filepath.Join("testdata", "*.jpg"))
+	if len(d.Files) != len(names) {
+		t.Fatalf("Expected %d files, got %v", len(names), d.Files)
+	}
+	for _, n := range d.Files {
+		if f := nextFrame(t, d, readImage(t, filepath.Base(n)).Bounds()); f.Source != n {
+			t.Errorf("Source %s, want %s", f.Source, n)
+		}
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+	// A looping source restarts from the first file.
+	d.Loop = true
+	if f, err := d.Next(); err != nil || f.Source != d.Files[0] {
+		t.Errorf("Expected %s after loop, got %v", d.Files[0], err)
+	}
+	if _, err := lcd.NewDirSource(t.TempDir(), true); err == nil {
+		t.Errorf("Expected error for empty directory")
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	s := imageServer(t, "test1.jpg", "lcd6.jpg")
+	src := lcd.NewHTTPSource(s.SnapshotURL(), 2*time.Second)
+	defer src.Close()
+	nextFrame(t, src, readImage(t, "test1.jpg").Bounds())
+	nextFrame(t, src, readImage(t, "lcd6.jpg").Bounds())
+	s.Fail(1)
+	if _, err := src.Next(); err == nil {
+		t.Errorf("Expected error from failed request")
+	}
+	nextFrame(t, src, readImage(t, "test1.jpg").Bounds())
+	// URLs are opened as snapshot sources.
+	if is, err := lcd.OpenSource(s.SnapshotURL(), time.Second); err != nil {
+		t.Errorf("OpenSource: %v", err)
+	} else if _, ok := is.(*lcd.HTTPSource); !ok {
+		t.Errorf("OpenSource: expected HTTPSource, got %T", is)
+	}
+	// The first frame of a stream is used as a snapshot.
+	src = lcd.NewHTTPSource(s.StreamURL(), 2*time.Second)
+	nextFrame(t, src, readImage(t, "lcd6.jpg").Bounds())
+	// Requests must complete within the timeout.
+	s.SetDelay(time.Second)
+	src = lcd.NewHTTPSource(s.SnapshotURL(), 100*time.Millisecond)
+	if _, err := src.Next(); err == nil {
+		t.Errorf("Expected timeout")
+	}
+}
+
+func TestMJPEGSource(t *testing.T) {
+	s := imageServer(t, "test1.jpg", "lcd6.jpg")
+	s.SetStreamLimit(3)
+	src := lcd.NewMJPEGSource(s.StreamURL(), 2*time.Second)
+	defer src.Close()
+	for i, n := range []string{"test1.jpg", "lcd6.jpg", "test1.jpg"} {
+		if f := nextFrame(t, src, readImage(t, n).Bounds()); f.Source != s.StreamURL() {
+			t.Errorf("Frame %d: source %s", i, f.Source)
+		}
+	}
+	if s.Requests() != 1 {
+		t.Errorf("Expected 1 request, got %d", s.Requests())
+	}
+	// The end of the stream is an error, and the next frame reopens it.
+	if _, err := src.Next(); err == nil {
+		t.Errorf("Expected error at end of stream")
+	}
+	nextFrame(t, src, readImage(t, "lcd6.jpg").Bounds())
+	if s.Requests() != 2 {
+		t.Errorf("Expected 2 requests, got %d", s.Requests())
+	}
+	// A stalled stream times out.
+	s.SetStreamLimit(0)
+	s.SetInterval(time.Second)
+	src = lcd.NewMJPEGSource(s.StreamURL(), 200*time.Millisecond)
+	defer src.Close()
+	nextFrame(t, src, readImage(t, "test1.jpg").Bounds())
+	if _, err := src.Next(); err == nil {
+		t.Errorf("Expected timeout")
+	}
+	// Snapshot URLs can be used as a source.
+	src = lcd.NewMJPEGSource(s.SnapshotURL(), 2*time.Second)
+	nextFrame(t, src, readImage(t, "lcd6.jpg").Bounds())
+}
@@ -0,0 +1,346 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CalibrationStore is a persistent store for calibration data.
+// A store may keep previous generations of the data as backups,
+// so that if the latest data is invalid, an older version can be used.
+type CalibrationStore interface {
+	// Read returns the data of the generation requested, where 0 is the
+	// most recent, 1 the one before that etc.
+	Read(gen int) ([]byte, error)
+	// Write saves the data as the most recent generation.
+	Write(data []byte) error
+	// Generations returns the maximum number of generations kept.
+	Generations() int
+}
+
+// SaveToStore saves the calibration data to the store.
+// Only the highest quality level sets are saved, up to max sets (0 for no limit).
+func (l *LcdDecoder) SaveToStore(s CalibrationStore, max int) error {
+	var b bytes.Buffer
+	if err := l.saveJSON(&b, max); err != nil {
+		return err
+	}
+	return s.Write(b.Bytes())
+}
+
+// RestoreFromStore restores the calibration data from the store.
+// If the latest generation is missing or invalid, the older generations are
+// tried in turn. The error from the latest generation is returned if
+// no valid data is found.
+func (l *LcdDecoder) RestoreFromStore(s CalibrationStore) (int, error) {
+	var firstErr error
+	for gen := 0; gen < s.Generations(); gen++ {
+		data, err := s.Read(gen)
+		if err == nil {
+			var n int
+			n, err = l.Restore(bytes.NewReader(data))
+			if err == nil && n == 0 {
+				err = fmt.Errorf("no calibration data")
+			}
+			if err == nil {
+				return n, nil
+			}
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return 0, firstErr
+}
+
+// Checkpoint saves the calibration to the Store (if set).
+// A failure to save is recorded in CheckpointErr and CheckpointFailures.
+func (l *LcdDecoder) Checkpoint() error {
+	if l.Store == nil {
+		return nil
+	}
+	l.LastCheckpoint = time.Now()
+	l.CheckpointErr = l.SaveToStore(l.Store, 0)
+	if l.CheckpointErr != nil {
+		l.CheckpointFailures++
+	}
+	return l.CheckpointErr
+}
+
+// checkpoint saves the calibration to the Store if the checkpoint interval has elapsed.
+func (l *LcdDecoder) checkpoint() {
+	if l.Store != nil && l.CheckpointInterval > 0 && time.Since(l.LastCheckpoint) >= l.CheckpointInterval {
+		l.Checkpoint()
+	}
+}
+
+// FileStore stores calibration data in a file.
+// The data is written to a temporary file which is then renamed, so that
+// the file is never left partially written. Before the rename, the existing
+// file is kept as a backup (named with a suffix of .1, .2 etc.), up to the
+// number of backups set.
+type FileStore struct {
+	Name    string // File name
+	Backups int    // Number of backup files kept
+}
+
+// Read returns the contents of the file or the backup file.
+func (f *FileStore) Read(gen int) ([]byte, error) {
+	if gen == 0 {
+		return ioutil.ReadFile(f.Name)
+	}
+	return ioutil.ReadFile(backupName(f.Name, gen))
+}
+
+// Write rotates the backups and atomically replaces the file.
+func (f *FileStore) Write(data []byte) error {
+	tmp, err := writeTemp(f.Name, data)
+	if err != nil {
+		return err
+	}
+	// Rotate the backups, discarding the oldest.
+	if f.Backups > 0 {
+		for i := f.Backups; i > 1; i-- {
+			os.Rename(backupName(f.Name, i-1), backupName(f.Name, i))
+		}
+		os.Rename(f.Name, backupName(f.Name, 1))
+	}
+	return renameTemp(tmp, f.Name)
+}
+
+// Generations returns the number of files, including backups.
+func (f *FileStore) Generations() int {
+	return f.Backups + 1
+}
+
+// MemoryStore stores calibration data in memory.
+type MemoryStore struct {
+	Backups int // Number of previous generations kept
+	mu      sync.Mutex
+	data    [][]byte
+}
+
+// Read returns a copy of the generation requested.
+func (m *MemoryStore) Read(gen int) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if gen < 0 || gen >= len(m.data) {
+		return nil, os.ErrNotExist
+	}
+	return append([]byte(nil), m.data[gen]...), nil
+}
+
+// Write saves a copy of the data as the most recent generation.
+func (m *MemoryStore) Write(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = append([][]byte{append([]byte(nil), data...)}, m.data...)
+	if len(m.data) > m.Backups+1 {
+		m.data = m.data[:m.Backups+1]
+	}
+	return nil
+}
+
+// Generations returns the number of generations kept.
+func (m *MemoryStore) Generations() int {
+	return m.Backups + 1
+}
+
+// KVStore stores calibration data under a key in a key/value database,
+// allowing calibration to be kept alongside other device state.
+// Backups are stored under the key with a suffix of .1, .2 etc.
+type KVStore struct {
+	DB      *KVFile // Database
+	Key     string  // Key of calibration data
+	Backups int     // Number of backups kept
+}
+
+// Read returns the data for the key or backup key.
+func (k *KVStore) Read(gen int) ([]byte, error) {
+	key := k.Key
+	if gen != 0 {
+		key = backupName(k.Key, gen)
+	}
+	if v, ok := k.DB.Get(key); ok {
+		return v, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// Write rotates the backups and saves the data under the key.
+func (k *KVStore) Write(data []byte) error {
+	return k.DB.Update(func(m map[string][]byte) {
+		if k.Backups > 0 {
+			for i := k.Backups; i > 1; i-- {
+				if v, ok := m[backupName(k.Key, i-1)]; ok {
+					m[backupName(k.Key, i)] = v
+				}
+			}
+			if v, ok := m[k.Key]; ok {
+				m[backupName(k.Key, 1)] = v
+			}
+		}
+		m[k.Key] = append([]byte(nil), data...)
+	})
+}
+
+// Generations returns the number of generations kept.
+func (k *KVStore) Generations() int {
+	return k.Backups + 1
+}
+
+// KVFile is a simple embedded key/value database held in a single file.
+// The entire database is held in memory, and the file is atomically
+// rewritten on each update. It is safe for concurrent use.
+type KVFile struct {
+	name string
+	mu   sync.Mutex
+	m    map[string][]byte
+}
+
+// OpenKVFile opens the database file, creating an empty database if the
+// file does not exist.
+func OpenKVFile(name string) (*KVFile, error) {
+	db := &KVFile{name: name, m: make(map[string][]byte)}
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &db.m); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return db, nil
+}
+
+// Get returns a copy of the value of the key.
+func (db *KVFile) Get(key string) ([]byte, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	v, ok := db.m[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), v...), true
+}
+
+// Put sets the value of the key, and writes the database.
+func (db *KVFile) Put(key string, value []byte) error {
+	return db.Update(func(m map[string][]byte) {
+		m[key] = append([]byte(nil), value...)
+	})
+}
+
+// Delete removes the key, and writes the database.
+func (db *KVFile) Delete(key string) error {
+	return db.Update(func(m map[string][]byte) {
+		delete(m, key)
+	})
+}
+
+// Keys returns a sorted list of the keys in the database.
+func (db *KVFile) Keys() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var keys []string
+	for k := range db.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Update calls f to modify the database, and then writes the database.
+// If the write fails, the changes are discarded.
+func (db *KVFile) Update(f func(map[string][]byte)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	nm := make(map[string][]byte, len(db.m))
+	for k, v := range db.m {
+		nm[k] = v
+	}
+	f(nm)
+	data, err := json.Marshal(nm)
+	if err != nil {
+		return err
+	}
+	tmp, err := writeTemp(db.name, data)
+	if err != nil {
+		return err
+	}
+	if err := renameTemp(tmp, db.name); err != nil {
+		return err
+	}
+	db.m = nm
+	return nil
+}
+
+// Write the data to a temporary file in the same directory as name,
+// returning the name of the temporary file. The temporary file has the
+// mode of the existing file, or 0644 if there is no existing file.
+func writeTemp(name string, data []byte) (string, error) {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(name); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	err = tmp.Chmod(mode)
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// Rename the temporary file to name, and sync the directory so that
+// the rename is persisted.
+func renameTemp(tmp, name string) error {
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if d, err := os.Open(filepath.Dir(name)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Return the name of a backup.
+func backupName(f string, i int) string {
+	return fmt.Sprintf("%s.%d", f, i)
+}
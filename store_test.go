@@ -0,0 +1,101 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd_test
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aamcrae/lcd"
+)
+
+func TestMemoryStore(t *testing.T) {
+	conf := readConfig(t, "test1.config")
+	l := calibratedDecoder(t, conf)
+	s := &lcd.MemoryStore{Backups: 1}
+	if err := l.SaveToStore(s, 0); err != nil {
+		t.Fatalf("SaveToStore: %v", err)
+	}
+	// A bad write is skipped when restoring.
+	s.Write([]byte("garbage"))
+	l2, _ := lcd.CreateLcdDecoder(conf)
+	if _, err := l2.RestoreFromStore(s); err != nil {
+		t.Fatalf("RestoreFromStore: %v", err)
+	}
+	if res := l2.Decode(readImage(t, "test1.jpg")); res.Text != "12345678." {
+		t.Errorf("After restore, expected 12345678., got %s", res.Text)
+	}
+	// Two bad writes drop the valid data.
+	s.Write([]byte("garbage"))
+	if _, err := l2.RestoreFromStore(s); err == nil {
+		t.Errorf("Expected restore error")
+	}
+}
+
+func TestKVStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lcdkv")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "state.db")
+	db, err := lcd.OpenKVFile(name)
+	if err != nil {
+		t.Fatalf("OpenKVFile: %v", err)
+	}
+	if err := db.Put("device", []byte("meter1")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	conf := readConfig(t, "test1.config")
+	l := calibratedDecoder(t, conf)
+	// Checkpoint automatically on recalibration.
+	l.Store = &lcd.KVStore{DB: db, Key: "calibration", Backups: 2}
+	l.CheckpointInterval = time.Minute
+	l.Good()
+	l.Recalibrate()
+	if l.CheckpointErr != nil || l.LastCheckpoint.IsZero() {
+		t.Fatalf("Checkpoint failed: %v", l.CheckpointErr)
+	}
+	// Re-open the database, and restore.
+	db2, err := lcd.OpenKVFile(name)
+	if err != nil {
+		t.Fatalf("OpenKVFile: %v", err)
+	}
+	if v, ok := db2.Get("device"); !ok || string(v) != "meter1" {
+		t.Errorf("Unexpected device value %q", v)
+	}
+	l2, _ := lcd.CreateLcdDecoder(conf)
+	if _, err := l2.RestoreFromStore(&lcd.KVStore{DB: db2, Key: "calibration"}); err != nil {
+		t.Fatalf("RestoreFromStore: %v", err)
+	}
+	if res := l2.Decode(readImage(t, "test1.jpg")); res.Text != "12345678." {
+		t.Errorf("After restore, expected 12345678., got %s", res.Text)
+	}
+	// A second checkpoint keeps the first as a backup.
+	if err := l.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	db3, err := lcd.OpenKVFile(name)
+	if err != nil {
+		t.Fatalf("OpenKVFile: %v", err)
+	}
+	if keys := db3.Keys(); len(keys) != 3 || keys[0] != "calibration" || keys[1] != "calibration.1" || keys[2] != "device" {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/rand"
+
+	"github.com/fogleman/gg"
+)
+
+// Shapes of rendered segments.
+const (
+	SegmentHex = iota // Segments with pointed ends
+	SegmentBox        // Rectangular segments
+)
+
+// SynthOptions controls the rendering of a synthetic display.
+// The zero value renders a clean display.
+type SynthOptions struct {
+	Width     int     // Image width (if 0, the configured size or the extent of the digits is used)
+	Height    int     // Image height
+	Shape     int     // Segment shape (SegmentHex or SegmentBox)
+	Thickness float64 // Segment width as a fraction of the template width (default 1.0)
+	Gap       float64 // Gap in pixels between adjacent segments (default 1)
+	Skew      float64 // Shear of each digit about its centre, in degrees (positive leans right)
+	DPRadius  float64 // Radius of the decimal point (default 0.6 of the segment width)
+	On        uint8   // Gray level of 'on' segments (default depends on polarity)
+	Off       uint8   // Gray level of the background (default depends on polarity)
+	Ghost     float64 // Visibility (0-1) of 'off' segments
+	Gradient  float64 // Change in brightness (0-1) from the left to the right of the image
+	Glare     float64 // Brightness (0-1) of a glare spot at a random position
+	Blur      int     // Radius in pixels of box blur
+	Noise     float64 // Standard deviation of gaussian noise, in gray levels
+	Quality   int     // If non-zero, the JPEG quality (1-100) used to add compression artefacts
+	Seed      int64   // Random seed for noise and glare
+}
+
+// Synthetic is a rendered synthetic display and its ground truth.
+type Synthetic struct {
+	Image image.Image // Rendered image
+	Text  string      // Expected decode, in the form returned by DecodeResult.Marked
+	Masks []int       // Segment bit mask of each digit
+	DP    []bool      // Decimal point of each digit
+}
+
+// Synthesize renders the string onto a synthetic display using the
+// digit layout from the configuration. The string has one character per digit,
+// each optionally followed by '.' to set the decimal point e.g "12.34".
+// The polarity of the display follows conf.Inverse (LED if true, otherwise LCD).
+func Synthesize(conf LcdConfig, s string, opt SynthOptions) (*Synthetic, error) {
+	var masks []int
+	var dp []bool
+	for i := 0; i < len(s); i++ {
+		if s[i] == '.' && len(dp) > 0 && !dp[len(dp)-1] {
+			dp[len(dp)-1] = true
+			continue
+		}
+		m, ok := reverseTable[s[i]]
+		if !ok {
+			return nil, fmt.Errorf("Unknown character (#%d - %c)", i, s[i])
+		}
+		masks = append(masks, m)
+		dp = append(dp, false)
+	}
+	return SynthesizeMasks(conf, masks, dp, opt)
+}
+
+// SynthesizeMasks renders a synthetic display with each digit's segments set
+// from the bit masks, allowing segment combinations that are not valid characters.
+// dp may be nil if no decimal points are set.
+func SynthesizeMasks(conf LcdConfig, masks []int, dp []bool, opt SynthOptions) (*Synthetic, error) {
+	l, err := CreateLcdDecoder(conf)
+	if err != nil {
+		return nil, err
+	}
+	if len(masks) != len(l.Digits) {
+		return nil, fmt.Errorf("%d digits supplied, configuration has %d", len(masks), len(l.Digits))
+	}
+	if dp == nil {
+		dp = make([]bool, len(masks))
+	} else if len(dp) != len(masks) {
+		return nil, fmt.Errorf("%d decimal points supplied, configuration has %d digits", len(dp), len(masks))
+	}
+	res := &Synthetic{Masks: append([]int(nil), masks...), DP: append([]bool(nil), dp...)}
+	var str []byte
+	for i, d := range l.Digits {
+		if dp[i] && len(d.dpb) == 0 {
+			return nil, fmt.Errorf("digit %d has no decimal point", i)
+		}
+		if c, ok := resultTable[masks[i]]; ok {
+			str = append(str, c)
+		} else {
+			str = append(str, 'X')
+		}
+		if dp[i] {
+			str = append(str, '.')
+		}
+	}
+	res.Text = string(str)
+	opt.defaults(conf, l)
+	rng := rand.New(rand.NewSource(opt.Seed))
+	c := gg.NewContext(opt.Width, opt.Height)
+	off := float64(opt.Off) / 255
+	on := float64(opt.On) / 255
+	c.SetRGB(off, off, off)
+	c.Clear()
+	for i, d := range l.Digits {
+		opt.drawDigit(c, d, masks[i], dp[i], off, on)
+	}
+	res.Image = opt.effects(c.Image(), rng)
+	return res, nil
+}
+
+// Set the default options.
+func (opt *SynthOptions) defaults(conf LcdConfig, l *LcdDecoder) {
+	if opt.Width == 0 || opt.Height == 0 {
+		if conf.Size[0] != 0 && conf.Size[1] != 0 {
+			opt.Width, opt.Height = conf.Size[0], conf.Size[1]
+		} else {
+			// Use the extent of the digits, plus a margin.
+			for _, d := range l.Digits {
+				for _, p := range append(PList{d.dp}, d.bb[:]...) {
+					opt.Width = max(opt.Width, p.X+d.lcd.line+10)
+					opt.Height = max(opt.Height, p.Y+d.lcd.line+10)
+				}
+			}
+		}
+	}
+	if opt.Thickness == 0 {
+		opt.Thickness = 1.0
+	}
+	if opt.Gap == 0 {
+		opt.Gap = 1
+	}
+	if opt.On == 0 && opt.Off == 0 {
+		if l.Inverse {
+			opt.On, opt.Off = 230, 20
+		} else {
+			opt.On, opt.Off = 40, 200
+		}
+	}
+}
+
+// fpoint is a point with floating point co-ordinates.
+type fpoint struct {
+	x, y float64
+}
+
+func fp(p Point) fpoint {
+	return fpoint{float64(p.X), float64(p.Y)}
+}
+
+func (p fpoint) add(q fpoint, f float64) fpoint {
+	return fpoint{p.x + q.x*f, p.y + q.y*f}
+}
+
+// Return the unit vector in the direction from s to e.
+func unit(s, e fpoint) fpoint {
+	x, y := e.x-s.x, e.y-s.y
+	l := math.Hypot(x, y)
+	if l == 0 {
+		return fpoint{}
+	}
+	return fpoint{x / l, y / l}
+}
+
+// Draw the segments and decimal point of one digit.
+func (opt *SynthOptions) drawDigit(c *gg.Context, d *Digit, mask int, dp bool, off, on float64) {
+	tl, tr, br, bl := fp(d.bb[TL]), fp(d.bb[TR]), fp(d.bb[BR]), fp(d.bb[BL])
+	ml := fpoint{(tl.x + bl.x) / 2, (tl.y + bl.y) / 2}
+	mr := fpoint{(tr.x + br.x) / 2, (tr.y + br.y) / 2}
+	w := float64(d.lcd.line) * opt.Thickness
+	// Each segment is drawn along the edge from s1 to s2, extending
+	// inwards towards e1 and e2. The order must match the segment enums.
+	edges := [SEGMENTS][4]fpoint{
+		S_TL: {tl, ml, tr, mr},
+		S_TM: {tl, tr, bl, br},
+		S_TR: {tr, mr, tl, ml},
+		S_BR: {mr, br, ml, bl},
+		S_BM: {bl, br, tl, tr},
+		S_BL: {ml, bl, mr, br},
+		// The middle segment is centred on the line between the middle points.
+		S_MM: {ml.add(unit(bl, tl), w/2), mr.add(unit(br, tr), w/2), bl, br},
+	}
+	ghost := off + (on-off)*opt.Ghost
+	cx := (tl.x + tr.x + br.x + bl.x) / 4
+	cy := (tl.y + tr.y + br.y + bl.y) / 4
+	c.Push()
+	c.ShearAbout(-math.Tan(opt.Skew*math.Pi/180), 0, cx, cy)
+	for i, e := range edges {
+		if mask&(1<<uint(i)) != 0 {
+			c.SetRGB(on, on, on)
+		} else if opt.Ghost != 0 {
+			c.SetRGB(ghost, ghost, ghost)
+		} else {
+			continue
+		}
+		for j, p := range opt.segment(e[0], e[1], e[2], e[3], w) {
+			if j == 0 {
+				c.MoveTo(p.x, p.y)
+			} else {
+				c.LineTo(p.x, p.y)
+			}
+		}
+		c.ClosePath()
+		c.Fill()
+	}
+	if len(d.dpb) > 0 && (dp || opt.Ghost != 0) {
+		if dp {
+			c.SetRGB(on, on, on)
+		} else {
+			c.SetRGB(ghost, ghost, ghost)
+		}
+		r := opt.DPRadius
+		if r == 0 {
+			r = float64(d.lcd.line) * 0.6
+		}
+		c.DrawCircle(float64(d.dp.X), float64(d.dp.Y), r)
+		c.Fill()
+	}
+	c.Pop()
+}
+
+// Return the outline of a segment of width w along the edge from s1 to s2,
+// extending inwards towards e1 and e2.
+func (opt *SynthOptions) segment(s1, s2, e1, e2 fpoint, w float64) []fpoint {
+	u := unit(s1, s2)
+	n1 := unit(s1, e1)
+	n2 := unit(s2, e2)
+	g := opt.Gap
+	if opt.Shape == SegmentBox {
+		return []fpoint{
+			s1.add(u, g),
+			s2.add(u, -g),
+			s2.add(n2, w).add(u, -g),
+			s1.add(n1, w).add(u, g),
+		}
+	}
+	return []fpoint{
+		s1.add(u, w/2+g),
+		s2.add(u, -w/2-g),
+		s2.add(n2, w/2).add(u, -g),
+		s2.add(n2, w).add(u, -w/2-g),
+		s1.add(n1, w).add(u, w/2+g),
+		s1.add(n1, w/2).add(u, g),
+	}
+}
+
+// Apply the lighting, blur, noise and compression effects to the image,
+// returning a grayscale image.
+func (opt *SynthOptions) effects(img image.Image, rng *rand.Rand) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	v := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v[y*w+x] = float64(color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y)
+		}
+	}
+	if opt.Gradient != 0 {
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				v[y*w+x] += opt.Gradient * 255 * (float64(x)/float64(w) - 0.5)
+			}
+		}
+	}
+	if opt.Glare != 0 {
+		gx, gy := rng.Float64()*float64(w), rng.Float64()*float64(h)
+		sigma := float64(min(w, h)) / 4
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				dx, dy := float64(x)-gx, float64(y)-gy
+				v[y*w+x] += opt.Glare * 255 * math.Exp(-(dx*dx+dy*dy)/(2*sigma*sigma))
+			}
+		}
+	}
+	if opt.Blur > 0 {
+		v = boxBlur(v, w, h, opt.Blur)
+	}
+	if opt.Noise != 0 {
+		for i := range v {
+			v[i] += rng.NormFloat64() * opt.Noise
+		}
+	}
+	g := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range v {
+		g.Pix[i] = uint8(math.Max(0, math.Min(255, math.Round(v[i]))))
+	}
+	if opt.Quality == 0 {
+		return g
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, g, &jpeg.Options{Quality: opt.Quality}); err != nil {
+		return g
+	}
+	j, err := jpeg.Decode(&buf)
+	if err != nil {
+		return g
+	}
+	return j
+}
+
+// Blur the values with a box blur of radius r, applied horizontally then vertically.
+func boxBlur(v []float64, w, h, r int) []float64 {
+	tmp := make([]float64, len(v))
+	out := make([]float64, len(v))
+	blur1(v, tmp, w, h, 1, w, r)
+	blur1(tmp, out, h, w, w, 1, r)
+	return out
+}
+
+// Blur the lines of values in one direction, where each of the n lines has l values,
+// step is the distance between values in a line, and next is the distance between lines.
+func blur1(in, out []float64, l, n, step, next, r int) {
+	for j := 0; j < n; j++ {
+		base := j * next
+		for i := 0; i < l; i++ {
+			var sum float64
+			var count int
+			for k := max(0, i-r); k <= min(l-1, i+r); k++ {
+				sum += in[base+k*step]
+				count++
+			}
+			out[base+i*step] = sum / float64(count)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd_test
+
+import (
+	"testing"
+
+	"github.com/aamcrae/lcd"
+)
+
+func TestSynthesize(t *testing.T) {
+	for _, name := range []string{"test1.config", "lcd6.config", "meter.config"} {
+		for _, led := range []bool{false, true} {
+			conf := readConfig(t, name)
+			conf.Inverse = led
+			l, err := lcd.CreateLcdDecoder(conf)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			n := len(conf.Digit)
+			cal, err := lcd.Synthesize(conf, "8888888888"[:n], lcd.SynthOptions{})
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			// Calibrate using all segments on, and then a digit with few segments on.
+			ones, _ := lcd.Synthesize(conf, "1111111111"[:n], lcd.SynthOptions{})
+			if err := l.Preset(cal.Image, cal.Text); err != nil {
+				t.Fatalf("%s: Preset: %v", name, err)
+			}
+			if err := l.Preset(ones.Image, ones.Text); err != nil {
+				t.Fatalf("%s: Preset: %v", name, err)
+			}
+			opts := []lcd.SynthOptions{
+				{},
+				{Shape: lcd.SegmentBox, Seed: 1},
+				{Noise: 10, Blur: 1, Seed: 2},
+				{Gradient: 0.2, Glare: 0.2, Quality: 50, Seed: 3},
+				{Skew: 2, Ghost: 0.1, Thickness: 0.9, Seed: 4},
+			}
+			for i, opt := range opts {
+				s := "0123456789"[i : i+n/2]
+				s = s + "9876543210"[:n-len(s)]
+				syn, err := lcd.Synthesize(conf, s, opt)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if syn.Text != s {
+					t.Errorf("%s: ground truth %q, expected %q", name, syn.Text, s)
+				}
+				if got := l.Decode(syn.Image).Marked(); got != syn.Text {
+					t.Errorf("%s (led %v, options %+v): decoded %q, expected %q", name, led, opt, got, syn.Text)
+				}
+			}
+		}
+	}
+}
+
+func TestSynthesizeMasks(t *testing.T) {
+	conf := readConfig(t, "lcd6.config")
+	// Invalid segment combination and decimal point.
+	masks := []int{lcd.M_TL | lcd.M_BR, lcd.M_TR | lcd.M_BR, 0, 0, 0, 0}
+	dp := []bool{false, true, false, false, false, false}
+	syn, err := lcd.SynthesizeMasks(conf, masks, dp, lcd.SynthOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if syn.Text != "X1.    " {
+		t.Errorf("Expected ground truth %q, got %q", "X1.    ", syn.Text)
+	}
+	if _, err := lcd.Synthesize(conf, "123", lcd.SynthOptions{}); err == nil {
+		t.Errorf("Expected error for wrong digit count")
+	}
+	if _, err := lcd.Synthesize(conf, "12345#", lcd.SynthOptions{}); err == nil {
+		t.Errorf("Expected error for unknown character")
+	}
+}
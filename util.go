@@ -44,21 +44,24 @@ func (l *LcdDecoder) MarkSamples(img *image.RGBA, fill bool) {
 	blue := color.RGBA{0, 0, 255, 50}
 	green := color.RGBA{0, 255, 0, 50}
 	white := color.RGBA{255, 255, 255, 255}
+	// Digit points are relative to the image origin.
+	x := img.Bounds().Min.X
+	y := img.Bounds().Min.Y
 	for _, d := range l.Digits {
-		drawBB(img, d.bb, white)
+		drawBB(img, d.bb.Offset(x, y), white)
 		ext := PList{d.tmr, d.tml, d.bmr, d.bml}
-		drawCross(img, ext, white)
+		drawCross(img, ext.Offset(x, y), white)
 		if fill {
-			drawFill(img, d.off, green)
+			drawFill(img, d.off.Offset(x, y), green)
 			for i := range d.seg {
-				drawFill(img, d.seg[i].points, red)
+				drawFill(img, d.seg[i].points.Offset(x, y), red)
 			}
 		}
 		if len(d.dpb) > 0 {
 			if fill {
-				drawFill(img, d.dpb, red)
+				drawFill(img, d.dpb.Offset(x, y), red)
 			}
-			drawCross(img, PList{d.dp}, blue)
+			drawCross(img, PList{d.dp}.Offset(x, y), blue)
 		}
 	}
 }
@@ -0,0 +1,279 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aamcrae/lcd"
+)
+
+var configFile = flag.String("config", "config", "Configuration file")
+var calFile = flag.String("calibration", "", "Calibration file")
+var format = flag.String("format", "csv", "Output format (csv or json)")
+var workers = flag.Int("workers", 1, "Number of images decoded in parallel")
+var manifest = flag.String("manifest", "", "Manifest of expected values")
+var names = flag.Bool("names", false, "Use file names as expected values")
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] directory|glob ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+}
+
+// result is the decode result of one image.
+type result struct {
+	File        string `json:"file"`
+	Text        string `json:"text"`
+	Marked      string `json:"marked"`
+	Invalid     int    `json:"invalid"`
+	Valid       []bool `json:"valid"`
+	DP          []bool `json:"dp"`
+	Confidence  int    `json:"confidence"`
+	Confidences []int  `json:"confidences"`
+	Expected    string `json:"expected,omitempty"`
+	Match       *bool  `json:"match,omitempty"`
+	Error       string `json:"error,omitempty"`
+	index       int
+}
+
+func main() {
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	// Check the format before creating the decoders.
+	if *format != "csv" && *format != "json" {
+		log.Fatalf("Unknown format %s", *format)
+	}
+	conf, err := lcd.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(*calFile) != 0 {
+		conf.Calibration = *calFile
+	}
+	files, err := imageFiles(flag.Args())
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	expected := make(map[string]string)
+	if len(*manifest) != 0 {
+		m, err := lcd.LoadManifest(*manifest)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, e := range m {
+			expected[filepath.Clean(e.Image)] = e.Expected
+		}
+	}
+	// Each worker has its own decoder, since decoders are not safe for concurrent use.
+	if *workers < 1 {
+		*workers = 1
+	}
+	jobs := make(chan int)
+	results := make(chan *result)
+	out := make([]*result, len(files))
+	for w := 0; w < *workers; w++ {
+		l, err := conf.Decoder()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		go func() {
+			for i := range jobs {
+				r := decode(conf, l, files[i])
+				r.index = i
+				results <- r
+			}
+		}()
+	}
+	go func() {
+		for i := range files {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+	// Results are written in the order of the files.
+	w := newWriter(*format)
+	var next, matched, compared, failed, goodDig, digits int
+	for range files {
+		r := <-results
+		out[r.index] = r
+		for next < len(out) && out[next] != nil {
+			r := out[next]
+			e, ok := expected[filepath.Clean(r.File)]
+			if !ok && *names {
+				e, ok = nameExpected(r.File), true
+			}
+			if ok {
+				r.Expected = e
+				good, total := lcd.CompareDigits(e, r.Marked)
+				m := good == total
+				r.Match = &m
+				compared++
+				goodDig += good
+				digits += total
+				if m {
+					matched++
+				}
+			}
+			if len(r.Error) != 0 {
+				failed++
+			}
+			w.write(r)
+			next++
+		}
+	}
+	w.flush()
+	fmt.Fprintf(os.Stderr, "%d images, %d errors", len(files), failed)
+	if compared > 0 {
+		fmt.Fprintf(os.Stderr, ", %d of %d matched expected (%d%%)", matched, compared, matched*100/compared)
+		if digits > 0 {
+			fmt.Fprintf(os.Stderr, ", %d of %d digits correct (%d%%)", goodDig, digits, goodDig*100/digits)
+		}
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// Decode one image.
+func decode(conf *lcd.ConfigFile, l *lcd.LcdDecoder, file string) *result {
+	r := &result{File: file}
+	img, err := lcd.ReadImage(file)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	res := l.Decode(conf.Prepare(img))
+	r.Text = res.Text
+	r.Marked = res.Marked()
+	r.Invalid = res.Invalid
+	r.Confidence = res.Confidence
+	if res.Err != nil {
+		r.Error = res.Err.Error()
+	}
+	for _, d := range res.Decodes {
+		r.Valid = append(r.Valid, d.Valid)
+		r.DP = append(r.DP, d.DP)
+		r.Confidences = append(r.Confidences, d.Confidence)
+	}
+	return r
+}
+
+// Return the list of image files from the arguments, which may be
+// directories or glob patterns.
+func imageFiles(args []string) ([]string, error) {
+	var files []string
+	for _, a := range args {
+		if fi, err := os.Stat(a); err == nil && fi.IsDir() {
+			entries, err := ioutil.ReadDir(a)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if !e.IsDir() && isImage(e.Name()) {
+					files = append(files, filepath.Join(a, e.Name()))
+				}
+			}
+			continue
+		}
+		m, err := filepath.Glob(a)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", a, err)
+		}
+		if len(m) == 0 {
+			return nil, fmt.Errorf("%s: no matching files", a)
+		}
+		sort.Strings(m)
+		files = append(files, m...)
+	}
+	return files, nil
+}
+
+// Return true if the file has an image suffix.
+func isImage(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+// Derive the expected value from the file name, which is the base name
+// up to the first '_', or without the suffix e.g "123.45_0001.jpg" is "123.45".
+func nameExpected(file string) string {
+	b := filepath.Base(file)
+	if i := strings.IndexByte(b, '_'); i >= 0 {
+		return b[:i]
+	}
+	return strings.TrimSuffix(b, filepath.Ext(b))
+}
+
+// writer outputs the results in CSV or JSON lines format.
+type writer struct {
+	c *csv.Writer
+	j *json.Encoder
+}
+
+func newWriter(f string) *writer {
+	switch f {
+	case "csv":
+		w := &writer{c: csv.NewWriter(os.Stdout)}
+		w.c.Write([]string{"file", "text", "marked", "invalid", "valid", "dp", "confidence", "confidences", "expected", "match", "error"})
+		return w
+	case "json":
+		return &writer{j: json.NewEncoder(os.Stdout)}
+	}
+	log.Fatalf("Unknown format %s", f)
+	return nil
+}
+
+func (w *writer) write(r *result) {
+	if w.j != nil {
+		if err := w.j.Encode(r); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	var valid, dp, conf []string
+	for i := range r.Valid {
+		valid = append(valid, strconv.FormatBool(r.Valid[i]))
+		dp = append(dp, strconv.FormatBool(r.DP[i]))
+		conf = append(conf, strconv.Itoa(r.Confidences[i]))
+	}
+	match := ""
+	if r.Match != nil {
+		match = strconv.FormatBool(*r.Match)
+	}
+	w.c.Write([]string{r.File, r.Text, r.Marked, strconv.Itoa(r.Invalid), strings.Join(valid, ";"),
+		strings.Join(dp, ";"), strconv.Itoa(r.Confidence), strings.Join(conf, ";"), r.Expected, match, r.Error})
+}
+
+func (w *writer) flush() {
+	if w.c != nil {
+		w.c.Flush()
+	}
+}
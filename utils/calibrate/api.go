@@ -0,0 +1,269 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aamcrae/lcd"
+)
+
+// Maximum size of an uploaded calibration file.
+const maxCalibrationSize = 16 << 20
+
+// loopState is the state owned by the main loop. API requests that
+// use the decoder are run by the main loop (see server.run), so that
+// the decoder is never accessed concurrently.
+type loopState struct {
+	decoder *lcd.LcdDecoder
+	watcher *lcd.ConfigWatcher
+	img     image.Image
+	calFile string
+}
+
+// presetRequest is the body of a preset request.
+type presetRequest struct {
+	Text string `json:"text"` // Digits shown in the current image
+}
+
+// Register the API handlers.
+func (s *server) apiInit(mux *http.ServeMux) {
+	mux.HandleFunc("/api/reading", s.apiReading)
+	mux.HandleFunc("/api/calibration", s.apiCalibration)
+	mux.HandleFunc("/api/calibration/file", s.apiCalibrationFile)
+	mux.HandleFunc("/api/config", s.apiConfig)
+	mux.HandleFunc("/api/capture", s.apiCapture)
+	mux.HandleFunc("/api/preset", s.apiPreset)
+}
+
+// Run f on the main loop, and wait for it to complete.
+// False is returned if the main loop did not accept the request in time.
+func (s *server) run(f func(st *loopState)) bool {
+	done := make(chan struct{})
+	req := func(st *loopState) {
+		f(st)
+		s.updateSummary(st.decoder.Summary())
+		close(done)
+	}
+	select {
+	case s.requests <- req:
+	case <-time.After(answerTimeout):
+		return false
+	}
+	<-done
+	return true
+}
+
+// Wait for the delay to expire or a capture to be requested, running
+// any API requests received in the meantime.
+func (s *server) wait(st *loopState, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			return
+		case <-s.capture:
+			return
+		case f := <-s.requests:
+			f(st)
+		}
+	}
+}
+
+// Send the latest reading.
+func (s *server) apiReading(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	r := s.reading
+	s.mu.Unlock()
+	if r == nil {
+		http.Error(w, "No reading yet", http.StatusNotFound)
+		return
+	}
+	sendJSON(w, r)
+}
+
+// Send the calibration summary.
+func (s *server) apiCalibration(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	sum := s.summary
+	s.mu.Unlock()
+	if sum == nil {
+		http.Error(w, "No decoder yet", http.StatusNotFound)
+		return
+	}
+	sendJSON(w, sum)
+}
+
+// Send the configuration file.
+func (s *server) apiConfig(w http.ResponseWriter, req *http.Request) {
+	c, err := s.readConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendJSON(w, c)
+}
+
+// Trigger a capture, and send the resulting reading.
+func (s *server) apiCapture(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	c := make(chan *lcd.Reading, 1)
+	s.mu.Lock()
+	if s.train.Waiting {
+		s.mu.Unlock()
+		http.Error(w, "Waiting for a training answer", http.StatusConflict)
+		return
+	}
+	s.waiters = append(s.waiters, c)
+	s.mu.Unlock()
+	select {
+	case s.capture <- struct{}{}:
+	default:
+		// A capture is already pending.
+	}
+	select {
+	case r := <-c:
+		if r == nil {
+			http.Error(w, "Image captured, but not decoded", http.StatusNotFound)
+			return
+		}
+		sendJSON(w, r)
+	case <-time.After(answerTimeout):
+		http.Error(w, "Timed out", http.StatusServiceUnavailable)
+	}
+}
+
+// Use the posted digits as a preset for the current image.
+func (s *server) apiPreset(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	var p presetRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxConfigSize)).Decode(&p); err != nil {
+		http.Error(w, fmt.Sprintf("Bad preset: %v", err), http.StatusBadRequest)
+		return
+	}
+	var msg string
+	var status int
+	ok := s.run(func(st *loopState) {
+		if st.img == nil {
+			msg, status = "No image yet", http.StatusConflict
+			return
+		}
+		if len(p.Text) != len(st.decoder.Digits) {
+			msg, status = fmt.Sprintf("Preset must be %d characters", len(st.decoder.Digits)), http.StatusBadRequest
+			return
+		}
+		dec := st.decoder.Decode(st.img)
+		msg = applyAnswer(st.decoder, st.img, dec, &trainAnswer{Action: actionCorrect, Text: p.Text}, st.calFile)
+	})
+	if !ok {
+		http.Error(w, "Timed out", http.StatusServiceUnavailable)
+		return
+	}
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+	log.Printf("Preset: %s", msg)
+	sendJSON(w, map[string]string{"result": msg})
+}
+
+// Download (GET) or upload (PUT or POST) the calibration data.
+// An uploaded calibration replaces the decoder's calibration, and
+// is saved to the calibration file (if set).
+func (s *server) apiCalibrationFile(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		var data []byte
+		var err error
+		ok := s.run(func(st *loopState) {
+			var store lcd.MemoryStore
+			if err = st.decoder.SaveToStore(&store, 0); err == nil {
+				data, err = store.Read(0)
+			}
+		})
+		if !ok {
+			http.Error(w, "Timed out", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"calibration.json\"")
+		w.Write(data)
+	case http.MethodPut, http.MethodPost:
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxCalibrationSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var n int
+		var saveErr error
+		ok := s.run(func(st *loopState) {
+			// Restore into a new decoder so that an invalid upload
+			// leaves the current calibration untouched. The restore is strict,
+			// since a partially restored calibration would replace calibrated
+			// digits with uncalibrated ones.
+			var nl *lcd.LcdDecoder
+			nl, err = lcd.CreateLcdDecoder(st.watcher.Config().Config)
+			if err != nil {
+				return
+			}
+			nl.StrictRestore = true
+			n, err = nl.Restore(bytes.NewReader(data))
+			if err == nil && n == 0 {
+				err = fmt.Errorf("no calibration data")
+			}
+			if err != nil {
+				return
+			}
+			st.decoder.MigrateCalibration(nl)
+			if len(st.calFile) > 0 {
+				saveErr = st.decoder.SaveToFile(st.calFile, 0)
+			}
+		})
+		if !ok {
+			http.Error(w, "Timed out", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Bad calibration: %v", err), http.StatusBadRequest)
+			return
+		}
+		if saveErr != nil {
+			http.Error(w, saveErr.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Restored %d calibration levels from upload", n)
+		sendJSON(w, map[string]int{"restored": n})
+	default:
+		http.Error(w, "GET, PUT or POST required", http.StatusMethodNotAllowed)
+	}
+}
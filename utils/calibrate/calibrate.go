@@ -18,16 +18,12 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
-	"image"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/aamcrae/lcd"
-	"gopkg.in/yaml.v3"
 )
 
 var configFile = flag.String("config", "config", "Configuration file")
@@ -35,83 +31,93 @@ var section = flag.String("section", "", "Configuration section name")
 var calFile = flag.String("calibration", "", "Calibration file")
 var read = flag.Bool("read", true, "If set, attempt to decode the digits.")
 var train = flag.Bool("train", true, "Enable training mode")
+var console = flag.Bool("console", false, "Enter training strings on the console instead of the web page")
 var port = flag.Int("port", 8100, "Port for image server")
 var refresh = flag.Int("refresh", 4, "Number of seconds before image refresh")
 var delay = flag.Int("delay", 1, "Number of seconds between each image read")
+var sourceFlag = flag.String("source", "", "Image source (URL, directory or file), overriding the config file")
+var timeout = flag.Duration("timeout", 10*time.Second, "Timeout for reading an image from a URL")
+var maxBackoff = flag.Duration("maxbackoff", time.Minute, "Maximum delay between retries after an image read fails")
+var stream = flag.Bool("stream", false, "Read the source URL as a MJPEG stream in the background, dropping stale frames")
 
 func init() {
 	flag.Parse()
 }
 
-type config struct {
-	Source string
-	Rotate float64
-	Config lcd.LcdConfig
-}
-
 func main() {
-	server, err := serverInit(*port, *refresh)
+	server, err := serverInit(*port, *refresh, *configFile)
 	if err != nil {
 		log.Fatalf("Server init failed %v", err)
 	}
-	client := http.Client{
-		Timeout: time.Duration(10) * time.Second,
+	watcher, err := lcd.NewConfigWatcher(*configFile)
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
-	var fileMod time.Time
-	var angle float64
-	var source string
-	var decoder *lcd.LcdDecoder
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		var in image.Image
-		// Check whether config file has changed.
-		fi, err := os.Stat(*configFile)
+	decoder := watcher.Decoder()
+	if len(*calFile) == 0 {
+		*calFile = watcher.Config().Calibration
+	} else if *read || *train {
+		// Restore into a new decoder, since the watcher's decoder has
+		// already restored the calibration from the config file.
+		decoder, err = lcd.CreateLcdDecoder(watcher.Config().Config)
 		if err != nil {
-			log.Fatalf("%s: %v", *configFile, err)
+			log.Fatalf("%v", err)
 		}
-		if fileMod != fi.ModTime() {
-			time.Sleep(1 * time.Second)
-			fileMod = fi.ModTime()
-			s, err := ioutil.ReadFile(*configFile)
-			if err != nil {
-				log.Fatalf("Can't read config %s: %v", *configFile, err)
-			}
-			var conf config
-			err = yaml.Unmarshal([]byte(s), &conf)
-			if err != nil {
-				log.Fatalf("config parse fail %s: %v", *configFile, err)
-			}
-			decoder, err = lcd.CreateLcdDecoder(conf.Config)
-			if err != nil {
-				log.Fatalf("LCD config failed %v", err)
-			}
-			if *read || *train {
-				if len(*calFile) != 0 {
-					if _, err := decoder.RestoreFromFile(*calFile); err != nil {
-						log.Printf("%s: %v\n", *calFile, err)
-					} else {
-						fmt.Printf("Calibration read from %s\n", *calFile)
-					}
+		watcher.SetDecoder(decoder)
+		if _, err := decoder.RestoreFromFile(*calFile); err != nil {
+			log.Printf("%s: %v\n", *calFile, err)
+		} else {
+			fmt.Printf("Calibration read from %s\n", *calFile)
+		}
+	}
+	server.updateDecoder(decoder)
+	server.updateSummary(decoder.Summary())
+	st := &loopState{decoder: decoder, watcher: watcher, calFile: *calFile}
+	srcName := sourceName(watcher.Config())
+	src, err := openSource(srcName)
+	if err != nil {
+		log.Fatalf("Source %s: %v", srcName, err)
+	}
+	var backoff time.Duration
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		// Check whether config file has changed. If so, the decoder is
+		// rebuilt, retaining the calibration of the unchanged digits.
+		if e, err := watcher.Check(); err != nil {
+			log.Printf("Config file %s: %v", *configFile, err)
+		} else if e != nil {
+			st.decoder = e.Decoder
+			server.updateDecoder(st.decoder)
+			log.Printf("Config file %s updated: %s", *configFile, e)
+			if n := sourceName(e.Config); n != srcName {
+				if ns, err := openSource(n); err != nil {
+					log.Printf("Source %s: %v (retaining %s)", n, err, srcName)
+				} else {
+					src.Close()
+					src, srcName = ns, n
+					log.Printf("Source changed to %s", srcName)
 				}
 			}
-			server.updateDecoder(decoder)
-			log.Printf("Config file %s updated", *configFile)
 		}
-		res, err := client.Get(source)
+		decoder := st.decoder
+		f, err := src.Next()
 		if err != nil {
-			log.Fatalf("Failed to retrieve source image from %s: %v", source, err)
-		}
-		in, _, err = image.Decode(res.Body)
-		res.Body.Close()
-		if err != nil {
-			log.Fatalf("Failed to decode image from %s: %v", source, err)
-		}
-		if angle != 0 {
-			in = lcd.RotateImage(in, angle)
+			// Retry with an increasing delay, up to the maximum.
+			backoff = nextBackoff(backoff, *maxBackoff)
+			log.Printf("Failed to read image from %s: %v (retrying in %s)", srcName, err, backoff)
+			server.wait(st, backoff)
+			continue
 		}
+		backoff = 0
+		in := watcher.Config().Prepare(f.Image)
+		st.img = in
 		var str strings.Builder
+		var reading *lcd.Reading
 		if *read && decoder != nil {
 			digits := decoder.Decode(in)
+			if digits.Err != nil {
+				log.Printf("%v", digits.Err)
+			}
 			for i := range digits.Decodes {
 				d := digits.Decodes[i]
 				if d.Valid {
@@ -120,35 +126,62 @@ func main() {
 					str.WriteRune('X')
 				}
 			}
+			reading = lcd.NewReading(digits, f.Time)
 			log.Printf("Segments = <%s>\n", str.String())
 		}
-		server.updateImage(in, str.String())
+		server.updateImage(in, str.String(), reading)
+		server.updateSummary(decoder.Summary())
 		if *train && decoder != nil {
 			dec := decoder.Decode(in)
-			fmt.Print("Enter string:")
-			str, _ := reader.ReadString('\n')
-			str = strings.TrimSuffix(str, "\n")
-			if len(str) == 0 {
-				decoder.CalibrateUsingScan(in, dec.Scans)
-				decoder.Good()
-			} else if len(str) == len(dec.Scans) {
-				decoder.Preset(in, str)
-				decoder.Good()
+			var a *trainAnswer
+			if *console {
+				fmt.Print("Enter string:")
+				str, _ := reader.ReadString('\n')
+				a = &trainAnswer{Action: actionCorrect, Text: strings.TrimSuffix(str, "\n")}
 			} else {
-				fmt.Printf("String length mismatch (should be %d chars, was %d) - string ignore\n", len(dec.Scans), len(str))
-				decoder.Bad()
+				a = server.trainFrame(st, dec)
 			}
-			// Write updated calibration.
-			if len(*calFile) > 0 {
-				decoder.Recalibrate()
-				if err := decoder.SaveToFile(*calFile, 0); err != nil {
-					log.Printf("%s: %v\n", *calFile, err)
-				} else {
-					fmt.Printf("Wrote calibration to %s\n", *calFile)
-				}
+			msg := applyAnswer(decoder, in, dec, a, *calFile)
+			server.trainResult(msg)
+			server.updateSummary(decoder.Summary())
+			if a.reply != nil {
+				a.reply <- msg
 			}
-		} else if *delay >= 0 {
-			time.Sleep(time.Duration(*delay) * time.Second)
+			fmt.Println(msg)
+		} else {
+			server.wait(st, time.Duration(*delay)*time.Second)
 		}
 	}
 }
+
+// Return the image source to use, which is the source flag if set,
+// otherwise the source from the configuration file.
+func sourceName(c *lcd.ConfigFile) string {
+	if len(*sourceFlag) != 0 {
+		return *sourceFlag
+	}
+	return c.Source
+}
+
+// Open the image source. If the stream flag is set, the source is read as
+// a MJPEG stream in the background, so that frames are not stale after
+// waiting for a training response.
+func openSource(name string) (lcd.ImageSource, error) {
+	if *stream {
+		return lcd.NewMJPEGReader(name, lcd.MJPEGOptions{Timeout: *timeout, MaxBackoff: *maxBackoff}), nil
+	}
+	return lcd.OpenSource(name, *timeout)
+}
+
+// Return the delay before the next retry, doubling the previous delay.
+func nextBackoff(d, max time.Duration) time.Duration {
+	if d == 0 {
+		d = time.Second
+	} else {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
@@ -15,6 +15,10 @@
 package main
 
 import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"image"
 	"image/draw"
@@ -22,6 +26,8 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/aamcrae/lcd"
 )
@@ -32,22 +38,47 @@ const (
 	outline = iota
 )
 
+// Maximum size of a configuration posted to the server.
+const maxConfigSize = 1 << 20
+
+// The configuration editor page, which is self-contained so that
+// it can be used without network access.
+//
+//go:embed editor.html
+var editorPage []byte
+
 type server struct {
-	port    int
-	refresh int
-	l       *lcd.LcdDecoder
-	img     image.Image
-	str     string
+	port       int
+	refresh    int
+	configFile string
+	mu         sync.Mutex
+	l          *lcd.LcdDecoder
+	img        image.Image
+	str        string
+	reading    *lcd.Reading
+	summary    *lcd.CalibrationSummary
+	waiters    []chan *lcd.Reading
+	train      trainState
+	answers    chan *trainAnswer
+	requests   chan func(*loopState)
+	capture    chan struct{}
 }
 
 // Initialise a http server.
-func serverInit(port, refresh int) (*server, error) {
+func serverInit(port, refresh int, configFile string) (*server, error) {
 	h, err := os.Hostname()
 	if err != nil {
 		return nil, fmt.Errorf("hostname error: %v", err)
 	}
 	mux := http.NewServeMux()
-	s := &server{port: port, refresh: refresh}
+	s := &server{
+		port:       port,
+		refresh:    refresh,
+		configFile: configFile,
+		answers:    make(chan *trainAnswer),
+		requests:   make(chan func(*loopState)),
+		capture:    make(chan struct{}, 1),
+	}
 	mux.HandleFunc("/plain.jpg", func(w http.ResponseWriter, req *http.Request) {
 		s.sendImage(w, plain)
 	})
@@ -66,6 +97,20 @@ func serverInit(port, refresh int) (*server, error) {
 	mux.HandleFunc("/filled.html", func(w http.ResponseWriter, req *http.Request) {
 		s.page(w, filled)
 	})
+	mux.HandleFunc("/editor.html", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(editorPage)
+	})
+	mux.HandleFunc("/editor/config", s.getConfig)
+	mux.HandleFunc("/editor/preview", s.preview)
+	mux.HandleFunc("/editor/save", s.save)
+	mux.HandleFunc("/train.html", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(trainPage)
+	})
+	mux.HandleFunc("/train/status", s.trainStatus)
+	mux.HandleFunc("/train/answer", s.trainAnswer)
+	s.apiInit(mux)
 	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		if req.URL.Path != "/" {
 			http.NotFound(w, req)
@@ -80,30 +125,60 @@ func serverInit(port, refresh int) (*server, error) {
 	return s, nil
 }
 
-// Update image
-func (s *server) updateImage(img image.Image, str string) {
+// Update image and reading (nil if the image was not decoded), and
+// pass the reading to any capture requests.
+func (s *server) updateImage(img image.Image, str string, r *lcd.Reading) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.img = img
 	s.str = str
+	if r != nil {
+		s.reading = r
+	}
+	for _, c := range s.waiters {
+		c <- r
+	}
+	s.waiters = nil
+}
+
+// Update calibration summary
+func (s *server) updateSummary(sum *lcd.CalibrationSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary = sum
 }
 
 // Update decoder
 func (s *server) updateDecoder(l *lcd.LcdDecoder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.l = l
 }
 
+// Return the current decoder, image and decoded string.
+func (s *server) current() (*lcd.LcdDecoder, image.Image, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.l, s.img, s.str
+}
+
 func (s *server) page(w http.ResponseWriter, req int) {
+	_, _, str := s.current()
 	w.Header().Set("Content-Type", "text/html")
 	fmt.Fprintf(w, "<html><head>")
 	if s.refresh > 0 {
 		fmt.Fprintf(w, "<meta http-equiv=\"refresh\" content=\"%d\">", s.refresh)
 	}
 	fmt.Fprintf(w, "</head><body>")
-	if len(s.str) != 0 {
-		fmt.Fprintf(w, "Decoded segments = %s<br>", s.str)
+	if len(str) != 0 {
+		fmt.Fprintf(w, "Decoded segments = %s<br>", str)
 	}
 	fmt.Fprintf(w, "<a href=\"plain.html\">Untouched image</a><br>")
 	fmt.Fprintf(w, "<a href=\"outline.html\">Outlined image</a><br>")
-	fmt.Fprintf(w, "<a href=\"filled.html\">Filled image</a><p>")
+	fmt.Fprintf(w, "<a href=\"filled.html\">Filled image</a><br>")
+	fmt.Fprintf(w, "<a href=\"editor.html\">Configuration editor</a><br>")
+	fmt.Fprintf(w, "<a href=\"train.html\">Training</a><br>")
+	fmt.Fprintf(w, "<a href=\"api/reading\">Latest reading (JSON)</a><p>")
 	switch req {
 	case plain:
 		fmt.Fprintf(w, "<img src=\"plain.jpg\">")
@@ -116,24 +191,139 @@ func (s *server) page(w http.ResponseWriter, req int) {
 }
 
 func (s *server) sendImage(w http.ResponseWriter, req int) {
-	if s.l == nil || s.img == nil {
+	l, img, _ := s.current()
+	if l == nil || img == nil {
 		http.Error(w, "No image yet", http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "image/jpeg")
-	var img image.Image
-	if req == plain {
-		img = s.img
-	} else {
-		// Copy the image first.
-		b := s.img.Bounds()
-		dst := image.NewRGBA(b)
-		draw.Draw(dst, b, s.img, b.Min, draw.Src)
-		s.l.MarkSamples(dst, req == filled)
-		img = dst
+	if req != plain {
+		img = markImage(l, img, req == filled)
 	}
 	err := jpeg.Encode(w, img, nil)
 	if err != nil {
 		http.Error(w, "JPEG encode error", http.StatusInternalServerError)
 	}
 }
+
+// Return a copy of the image with the digit samples marked.
+func markImage(l *lcd.LcdDecoder, img image.Image, fill bool) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	l.MarkSamples(dst, fill)
+	return dst
+}
+
+// Read the configuration file without resolving the file names, so
+// that the configuration can be written back unchanged.
+func (s *server) readConfig() (*lcd.ConfigFile, error) {
+	f, err := os.Open(s.configFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return lcd.ReadConfig(f, strings.HasSuffix(strings.ToLower(s.configFile), ".json"))
+}
+
+// Decode a posted LcdConfig.
+func decodeConfig(w http.ResponseWriter, req *http.Request) (*lcd.LcdConfig, bool) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	var conf lcd.LcdConfig
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxConfigSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&conf); err != nil {
+		http.Error(w, fmt.Sprintf("Bad configuration: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	return &conf, true
+}
+
+func sendJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("JSON encode: %v", err)
+	}
+}
+
+// Send the LcdConfig from the configuration file.
+func (s *server) getConfig(w http.ResponseWriter, req *http.Request) {
+	c, err := s.readConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendJSON(w, &c.Config)
+}
+
+// previewResult is the result of previewing an edited configuration.
+type previewResult struct {
+	Image    string   `json:"image,omitempty"` // Marked image as a data URL
+	Width    int      `json:"width"`           // Image width
+	Height   int      `json:"height"`          // Image height
+	Decoded  string   `json:"decoded"`         // Last decoded digits
+	Error    string   `json:"error,omitempty"` // Error creating decoder
+	Findings []string `json:"findings"`        // Validation findings
+}
+
+// Preview the posted configuration by marking the sampled regions
+// on the current image.
+func (s *server) preview(w http.ResponseWriter, req *http.Request) {
+	conf, ok := decodeConfig(w, req)
+	if !ok {
+		return
+	}
+	_, img, str := s.current()
+	res := previewResult{Decoded: str}
+	if img != nil {
+		res.Findings = lcd.ValidateConfigImage(*conf, img)
+	} else {
+		res.Findings = lcd.ValidateConfig(*conf)
+	}
+	l, err := lcd.CreateLcdDecoder(*conf)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if img != nil {
+		if l != nil {
+			img = markImage(l, img, true)
+		}
+		var b bytes.Buffer
+		if err := jpeg.Encode(&b, img, nil); err != nil {
+			http.Error(w, "JPEG encode error", http.StatusInternalServerError)
+			return
+		}
+		res.Image = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(b.Bytes())
+		res.Width = img.Bounds().Dx()
+		res.Height = img.Bounds().Dy()
+	}
+	sendJSON(w, &res)
+}
+
+// Save the posted configuration to the configuration file, retaining the
+// other settings in the file.
+func (s *server) save(w http.ResponseWriter, req *http.Request) {
+	conf, ok := decodeConfig(w, req)
+	if !ok {
+		return
+	}
+	if _, err := lcd.CreateLcdDecoder(*conf); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid configuration: %v", err), http.StatusBadRequest)
+		return
+	}
+	c, err := s.readConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Config = *conf
+	if err := lcd.SaveConfig(s.configFile, c); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Configuration saved to %s", s.configFile)
+	sendJSON(w, map[string]string{"saved": s.configFile})
+}
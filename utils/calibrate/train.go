@@ -0,0 +1,187 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"time"
+
+	"github.com/aamcrae/lcd"
+)
+
+// Training actions.
+const (
+	actionConfirm = "confirm" // Decoded digits are correct
+	actionCorrect = "correct" // Digits have been corrected
+	actionSkip    = "skip"    // Frame is not used for training
+)
+
+// Time allowed for the main loop to apply an answer.
+const answerTimeout = 30 * time.Second
+
+// The training page.
+//
+//go:embed train.html
+var trainPage []byte
+
+// trainAnswer is the operator's response to a training frame.
+type trainAnswer struct {
+	Seq    int         `json:"seq"`    // Frame sequence number
+	Action string      `json:"action"` // confirm, correct or skip
+	Text   string      `json:"text"`   // Corrected digits
+	reply  chan string // Result of applying the answer
+}
+
+// trainState is the state of training presented to the operator.
+type trainState struct {
+	Seq     int    `json:"seq"`     // Sequence number of current frame
+	Waiting bool   `json:"waiting"` // True if waiting for an answer
+	Guess   string `json:"guess"`   // Decoded digits, one character per digit ('X' if invalid)
+	Marked  string `json:"marked"`  // Decoded digits including decimal points
+	Valid   bool   `json:"valid"`   // True if all digits were decoded
+	Last    string `json:"last"`    // Result of the last answer
+}
+
+// Present the decoded frame for training, and wait for the operator's answer,
+// running any API requests received in the meantime.
+// The image must already have been set using updateImage.
+func (s *server) trainFrame(st *loopState, res *lcd.DecodeResult) *trainAnswer {
+	var guess []byte
+	for _, d := range res.Decodes {
+		if d.Valid {
+			guess = append(guess, d.Char)
+		} else {
+			guess = append(guess, 'X')
+		}
+	}
+	s.mu.Lock()
+	s.train.Seq++
+	seq := s.train.Seq
+	s.train.Waiting = true
+	s.train.Guess = string(guess)
+	s.train.Marked = res.Marked()
+	s.train.Valid = res.Invalid == 0
+	s.mu.Unlock()
+	for {
+		var a *trainAnswer
+		select {
+		case a = <-s.answers:
+		case f := <-s.requests:
+			f(st)
+			continue
+		}
+		if a.Seq == seq {
+			s.mu.Lock()
+			s.train.Waiting = false
+			s.mu.Unlock()
+			return a
+		}
+		a.reply <- fmt.Sprintf("Frame %d is no longer current", a.Seq)
+	}
+}
+
+// Record the result of the last answer.
+func (s *server) trainResult(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.train.Last = msg
+}
+
+// Send the training state.
+func (s *server) trainStatus(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	st := s.train
+	s.mu.Unlock()
+	sendJSON(w, &st)
+}
+
+// Accept an answer from the operator, and pass it to the main loop.
+func (s *server) trainAnswer(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	a := new(trainAnswer)
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxConfigSize)).Decode(a); err != nil {
+		http.Error(w, fmt.Sprintf("Bad answer: %v", err), http.StatusBadRequest)
+		return
+	}
+	switch a.Action {
+	case actionConfirm, actionCorrect, actionSkip:
+	default:
+		http.Error(w, fmt.Sprintf("Unknown action %q", a.Action), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	waiting := s.train.Waiting && s.train.Seq == a.Seq
+	s.mu.Unlock()
+	if !waiting {
+		http.Error(w, "Not waiting for an answer to this frame", http.StatusConflict)
+		return
+	}
+	a.reply = make(chan string, 1)
+	select {
+	case s.answers <- a:
+	case <-time.After(answerTimeout):
+		http.Error(w, "Timed out", http.StatusServiceUnavailable)
+		return
+	}
+	select {
+	case msg := <-a.reply:
+		sendJSON(w, map[string]string{"result": msg})
+	case <-time.After(answerTimeout):
+		http.Error(w, "Timed out", http.StatusServiceUnavailable)
+	}
+}
+
+// Apply the training answer to the decoder, returning a description of the result.
+// An empty string (or a confirmation) uses the decoded result to calibrate the decoder,
+// otherwise the string is used as the preset digits.
+// Unless the frame is skipped, the decoder is then recalibrated, and the calibration
+// saved to calFile (if set).
+func applyAnswer(decoder *lcd.LcdDecoder, img image.Image, dec *lcd.DecodeResult, a *trainAnswer, calFile string) string {
+	var msg string
+	switch {
+	case a.Action == actionSkip:
+		return "Frame skipped"
+	case a.Action == actionConfirm || len(a.Text) == 0:
+		decoder.CalibrateUsingScan(img, dec.Scans)
+		decoder.Good()
+		msg = "Calibrated using decoded digits"
+	case len(a.Text) == len(dec.Scans):
+		if err := decoder.Preset(img, a.Text); err != nil {
+			return fmt.Sprintf("Preset failed: %v", err)
+		}
+		decoder.Good()
+		msg = fmt.Sprintf("Calibrated using %q", a.Text)
+	default:
+		decoder.Bad()
+		msg = fmt.Sprintf("String length mismatch (should be %d chars, was %d) - string ignored", len(dec.Scans), len(a.Text))
+	}
+	// Write updated calibration.
+	if len(calFile) > 0 {
+		decoder.Recalibrate()
+		if err := decoder.SaveToFile(calFile, 0); err != nil {
+			msg = fmt.Sprintf("%s; %s: %v", msg, calFile, err)
+		} else {
+			msg = fmt.Sprintf("%s; wrote calibration to %s", msg, calFile)
+		}
+	}
+	return msg
+}
@@ -0,0 +1,195 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aamcrae/lcd"
+)
+
+var configFile = flag.String("config", "config", "Configuration file")
+var calFile = flag.String("calibration", "", "Calibration file, overriding the config file")
+var manifest = flag.String("manifest", "", "Manifest that the labelled frames are appended to")
+var preset = flag.Bool("preset", false, "Include the preset digits in the manifest entries")
+var reset = flag.Bool("reset", false, "Build the calibration from the labelled frames only, discarding the existing calibration")
+var all = flag.Bool("all", false, "Also show frames that have already been labelled")
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] capture-directory\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+}
+
+func main() {
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	conf, err := lcd.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(*calFile) != 0 {
+		conf.Calibration = *calFile
+	}
+	var l *lcd.LcdDecoder
+	if *reset {
+		l, err = lcd.CreateLcdDecoder(conf.Config)
+	} else {
+		l, err = conf.Decoder()
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	el, err := lcd.LoadCaptures(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	var mf *os.File
+	if len(*manifest) != 0 {
+		mf, err = os.OpenFile(*manifest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer mf.Close()
+	}
+	in := bufio.NewReader(os.Stdin)
+	var labelled, applied int
+	var quit bool
+	for i, e := range el {
+		label := e.Label
+		if !quit && (len(e.Label) == 0 || *all) {
+			var l2 string
+			l2, quit = prompt(in, e, i, len(el), len(l.Digits))
+			if len(l2) != 0 {
+				label = l2
+			}
+		}
+		// When resetting, the frames labelled previously are also used
+		// to build the calibration.
+		if len(label) == 0 || (label == e.Label && !*reset) {
+			continue
+		}
+		// Calibrate using the label.
+		img, err := lcd.ReadImage(e.Frame)
+		if err != nil {
+			fmt.Printf("%s: %v\n", e.Frame, err)
+			continue
+		}
+		p, err := lcd.LabelPreset(label, len(l.Digits))
+		if err != nil {
+			fmt.Printf("%s: %v\n", e.Name, err)
+			continue
+		}
+		if err := l.Preset(conf.Prepare(img), p); err != nil {
+			fmt.Printf("Preset failed: %v\n", err)
+			continue
+		}
+		l.Good()
+		applied++
+		if label == e.Label {
+			continue
+		}
+		labelled++
+		e.Label = label
+		if err := e.Save(); err != nil {
+			fmt.Printf("%s: %v\n", e.Name, err)
+		}
+		if mf != nil {
+			me := lcd.ManifestEntry{Image: manifestPath(*manifest, e.Frame), Expected: label}
+			if *preset {
+				me.Preset = p
+			}
+			if err := lcd.WriteManifestEntry(mf, me); err != nil {
+				log.Fatalf("%s: %v", *manifest, err)
+			}
+		}
+	}
+	fmt.Printf("%d frames labelled, %d used for calibration\n", labelled, applied)
+	if applied > 0 && len(conf.Calibration) != 0 {
+		l.Recalibrate()
+		if err := l.SaveToFile(conf.Calibration, 0); err != nil {
+			log.Fatalf("%s: %v", conf.Calibration, err)
+		}
+		fmt.Printf("Wrote calibration to %s\n", conf.Calibration)
+	}
+}
+
+// Show the entry and read the label, returning an empty label if the
+// frame is skipped, and true if the operator has quit.
+func prompt(in *bufio.Reader, e *lcd.CaptureEntry, i, n, digits int) (string, bool) {
+	fmt.Printf("\n[%d/%d] %s (%s)\n", i+1, n, e.Frame, e.Time.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Decoded %q, %d invalid digits, confidence %d\n", e.Marked, e.Invalid, e.Confidence)
+	for d, dg := range e.Digits {
+		if !dg.Valid || dg.Confidence < 50 {
+			fmt.Printf("  Digit %d: valid %v, mask 0x%02x, confidence %d, segments %v\n", d, dg.Valid, dg.Mask, dg.Confidence, dg.Segments)
+		}
+	}
+	if len(e.Label) != 0 {
+		fmt.Printf("Labelled %q\n", e.Label)
+	}
+	for {
+		fmt.Print("Label (Enter to skip, '=' to accept the decode, 'q' to quit): ")
+		s, err := in.ReadString('\n')
+		if err != nil && (err != io.EOF || len(s) == 0) {
+			return "", true
+		}
+		s = strings.TrimRight(s, "\r\n")
+		switch strings.TrimSpace(s) {
+		case "":
+			return "", false
+		case "q":
+			return "", true
+		case "=":
+			if e.Invalid != 0 {
+				fmt.Println("The decode has invalid digits")
+				continue
+			}
+			s = e.Marked
+		}
+		if _, err := lcd.LabelPreset(s, digits); err != nil {
+			fmt.Printf("Bad label: %v\n", err)
+			continue
+		}
+		return s, false
+	}
+}
+
+// Return the image file name relative to the directory of the manifest,
+// since manifest names are resolved relative to the manifest.
+func manifestPath(manifest, image string) string {
+	dir, err := filepath.Abs(filepath.Dir(manifest))
+	if err != nil {
+		return image
+	}
+	abs, err := filepath.Abs(image)
+	if err != nil {
+		return image
+	}
+	if rel, err := filepath.Rel(dir, abs); err == nil {
+		return rel
+	}
+	return abs
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aamcrae/lcd"
+	"github.com/aamcrae/lcd/mqtt"
+)
+
+// publisher publishes readings.
+type publisher interface {
+	publish(r *lcd.Reading) error
+	close() error
+}
+
+// healthPublisher is a publisher that also reports the calibration state.
+type healthPublisher interface {
+	health(s *lcd.CalibrationSummary) error
+}
+
+// Create the publishers selected by the flags.
+func publishers() ([]publisher, error) {
+	var pubs []publisher
+	switch *output {
+	case "":
+	case "-":
+		pubs = append(pubs, &jsonPublisher{w: os.Stdout})
+	default:
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		pubs = append(pubs, &jsonPublisher{w: f, c: f})
+	}
+	if len(*post) != 0 {
+		pubs = append(pubs, &httpPublisher{url: *post, client: http.Client{Timeout: *timeout}})
+	}
+	if len(*mqttBroker) != 0 {
+		if *mqttQoS < 0 || *mqttQoS > 1 {
+			return nil, fmt.Errorf("mqtt-qos: QoS %d not supported", *mqttQoS)
+		}
+		p, err := mqtt.NewPublisher(mqtt.Options{
+			ClientOptions: mqtt.ClientOptions{
+				Broker:    *mqttBroker,
+				ClientID:  *mqttID,
+				Username:  *mqttUser,
+				Password:  *mqttPassword,
+				KeepAlive: time.Minute,
+				Timeout:   *timeout,
+			},
+			Topic:     *mqttTopic,
+			QoS:       byte(*mqttQoS),
+			Retain:    *mqttRetain,
+			Discovery: *mqttDiscovery,
+			Name:      *display,
+			Unit:      *mqttUnit,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("mqtt: %v", err)
+		}
+		pubs = append(pubs, &mqttPublisher{p})
+	}
+	return pubs, nil
+}
+
+// jsonPublisher writes readings as JSON lines.
+type jsonPublisher struct {
+	w io.Writer
+	c io.Closer
+}
+
+func (j *jsonPublisher) publish(r *lcd.Reading) error {
+	return json.NewEncoder(j.w).Encode(r)
+}
+
+func (j *jsonPublisher) close() error {
+	if j.c != nil {
+		return j.c.Close()
+	}
+	return nil
+}
+
+// httpPublisher posts readings as JSON to a URL.
+type httpPublisher struct {
+	url    string
+	client http.Client
+}
+
+func (h *httpPublisher) publish(r *lcd.Reading) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	res, err := h.client.Post(h.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", h.url, res.Status)
+	}
+	return nil
+}
+
+func (h *httpPublisher) close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+// mqttPublisher publishes readings and the calibration state to a MQTT broker.
+type mqttPublisher struct {
+	p *mqtt.Publisher
+}
+
+func (m *mqttPublisher) publish(r *lcd.Reading) error {
+	return m.p.Publish(r)
+}
+
+func (m *mqttPublisher) health(s *lcd.CalibrationSummary) error {
+	return m.p.PublishHealth(s)
+}
+
+func (m *mqttPublisher) close() error {
+	return m.p.Close()
+}
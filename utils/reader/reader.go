@@ -0,0 +1,327 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aamcrae/lcd"
+)
+
+var configFile = flag.String("config", "config", "Configuration file")
+var calFile = flag.String("calibration", "", "Calibration file, overriding the config file")
+var sourceFlag = flag.String("source", "", "Image source (URL, directory or file), overriding the config file")
+var stream = flag.Bool("stream", false, "Read the source URL as a MJPEG stream in the background, dropping stale frames")
+var interval = flag.Duration("interval", 10*time.Second, "Interval between readings")
+var timeout = flag.Duration("timeout", 10*time.Second, "Timeout for reading an image from a URL")
+var maxBackoff = flag.Duration("maxbackoff", time.Minute, "Maximum delay between retries after an image read fails")
+var recalibrate = flag.Int("recalibrate", 50, "Number of frames between each recalibration")
+var adjust = flag.Bool("adjust", true, "Adjust the calibration levels using valid decodes")
+var saveInterval = flag.Duration("save", 10*time.Minute, "Minimum interval between saving the calibration")
+var output = flag.String("output", "-", "File that readings are appended to as JSON lines ('-' for stdout, empty for none)")
+var post = flag.String("post", "", "URL that each reading is posted to as JSON")
+var all = flag.Bool("all", false, "Publish readings that have invalid digits")
+var metricsAddr = flag.String("metrics", "", "Address (e.g ':9100') to serve Prometheus metrics on at /metrics")
+var display = flag.String("display", "", "Display name, added as a label to the metrics")
+var historyDir = flag.String("history", "", "Directory that the history of decodes is logged to")
+var historySize = flag.Int64("history-size", 10<<20, "Size of the history log before it is rotated")
+var historyBackups = flag.Int("history-backups", 4, "Number of rotated history logs kept")
+var historyFrames = flag.Bool("history-frames", false, "Save the frames of invalid and low confidence decodes in the history directory")
+var historyConfidence = flag.Int("history-confidence", 50, "Frames of decodes with a lower confidence are saved in the history")
+var captureDir = flag.String("capture", "", "Directory that failing and borderline frames are saved to for labelling")
+var captureConfidence = flag.Int("capture-confidence", 50, "Frames of decodes with a lower confidence are captured")
+var captureMax = flag.Int("capture-max", 1000, "Maximum number of frames in the capture directory (0 for no limit)")
+var mqttBroker = flag.String("mqtt", "", "MQTT broker address (host:port) that readings are published to")
+var mqttID = flag.String("mqtt-id", "lcd-reader", "MQTT client identifier")
+var mqttTopic = flag.String("mqtt-topic", "", "MQTT base topic (default lcd/<client id>)")
+var mqttQoS = flag.Int("mqtt-qos", 1, "MQTT QoS of published messages (0 or 1)")
+var mqttRetain = flag.Bool("mqtt-retain", true, "Retain the published MQTT messages")
+var mqttUser = flag.String("mqtt-user", "", "MQTT user name")
+var mqttPassword = flag.String("mqtt-password", "", "MQTT password")
+var mqttDiscovery = flag.String("mqtt-discovery", "", "Home Assistant discovery prefix (e.g 'homeassistant'), or empty for no discovery")
+var mqttUnit = flag.String("mqtt-unit", "", "Unit of measurement of the reading for Home Assistant discovery")
+
+func init() {
+	flag.Parse()
+}
+
+func main() {
+	conf, err := lcd.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(*calFile) != 0 {
+		conf.Calibration = *calFile
+	}
+	decoder, err := conf.Decoder()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(conf.Calibration) != 0 {
+		decoder.Store = &lcd.FileStore{Name: conf.Calibration, Backups: decoder.Backups}
+		decoder.CheckpointInterval = *saveInterval
+		decoder.LastCheckpoint = time.Now()
+	} else {
+		log.Printf("No calibration file, calibration will not be saved")
+	}
+	srcName := conf.Source
+	if len(*sourceFlag) != 0 {
+		srcName = *sourceFlag
+	}
+	var src lcd.ImageSource
+	if *stream {
+		src = lcd.NewMJPEGReader(srcName, lcd.MJPEGOptions{Timeout: *timeout, MaxBackoff: *maxBackoff})
+	} else if src, err = lcd.OpenSource(srcName, *timeout); err != nil {
+		log.Fatalf("Source %s: %v", srcName, err)
+	}
+	pubs, err := publishers()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	r := &reader{conf: conf, decoder: decoder, src: src, srcName: srcName, pubs: pubs, stop: make(chan struct{})}
+	if len(*historyDir) != 0 {
+		if r.history, err = lcd.NewReadingLog(*historyDir); err != nil {
+			log.Fatalf("History: %v", err)
+		}
+		r.history.MaxSize = *historySize
+		r.history.Backups = *historyBackups
+		r.history.SaveFrames = *historyFrames
+		r.history.MinConfidence = *historyConfidence
+	}
+	if len(*captureDir) != 0 {
+		if r.capture, err = lcd.NewCapture(*captureDir); err != nil {
+			log.Fatalf("Capture: %v", err)
+		}
+		r.capture.MinConfidence = *captureConfidence
+		r.capture.MaxFrames = *captureMax
+	}
+	r.health()
+	if len(*metricsAddr) != 0 {
+		var labels map[string]string
+		if len(*display) != 0 {
+			labels = map[string]string{"display": *display}
+		}
+		r.metrics = lcd.NewMetrics(labels)
+		r.metrics.Update(decoder)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", r.metrics)
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, mux))
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		r.run()
+		close(done)
+	}()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case s := <-sig:
+		log.Printf("Received %v, shutting down", s)
+		close(r.stop)
+		if *stream {
+			// Unblock a wait for the next frame.
+			src.Close()
+		}
+		<-done
+	case <-done:
+	}
+	r.shutdown()
+}
+
+// reader reads and decodes the images, and publishes the readings.
+type reader struct {
+	conf      *lcd.ConfigFile
+	decoder   *lcd.LcdDecoder
+	src       lcd.ImageSource
+	srcName   string
+	pubs      []publisher
+	stop      chan struct{}
+	frames    int
+	metrics   *lcd.Metrics
+	history   *lcd.ReadingLog
+	capture   *lcd.Capture
+	decodeErr string // Last decode error reported
+}
+
+// Read frames until stopped, or the source has no more frames.
+func (r *reader) run() {
+	var backoff time.Duration
+	next := time.Now()
+	for {
+		// Wait until the next reading is due.
+		if !r.sleep(time.Until(next)) {
+			return
+		}
+		next = next.Add(*interval)
+		if now := time.Now(); next.Before(now) {
+			// Readings have fallen behind, so skip the missed readings.
+			next = now.Add(*interval)
+		}
+		f, err := r.src.Next()
+		if err != nil {
+			if r.stopped() {
+				return
+			}
+			if err == io.EOF {
+				log.Printf("%s: no more images", r.srcName)
+				return
+			}
+			// Retry with an increasing delay, up to the maximum.
+			if backoff == 0 {
+				backoff = time.Second
+			} else if backoff *= 2; backoff > *maxBackoff {
+				backoff = *maxBackoff
+			}
+			log.Printf("Failed to read image from %s: %v (retrying in %s)", r.srcName, err, backoff)
+			next = time.Now().Add(backoff)
+			continue
+		}
+		backoff = 0
+		r.process(f)
+	}
+}
+
+// Decode the frame, update the calibration and publish the reading.
+func (r *reader) process(f *lcd.Frame) {
+	img := r.conf.Prepare(f.Image)
+	start := time.Now()
+	res := r.decoder.Decode(img)
+	if res.Err != nil {
+		// Report the error when it first occurs, or changes.
+		if msg := res.Err.Error(); msg != r.decodeErr {
+			log.Printf("%s: %s", r.srcName, msg)
+			r.decodeErr = msg
+		}
+	} else {
+		r.decodeErr = ""
+	}
+	if r.metrics != nil {
+		r.metrics.Observe(res, time.Since(start))
+	}
+	if r.history != nil {
+		if err := r.history.Add(res, f.Image, f.Time); err != nil {
+			log.Printf("History: %v", err)
+		}
+	}
+	if r.capture != nil {
+		if _, err := r.capture.Add(res, f.Image, f.Time); err != nil {
+			log.Printf("Capture: %v", err)
+		}
+	}
+	if res.Invalid == 0 {
+		if *adjust {
+			r.decoder.CalibrateUsingScan(img, res.Scans)
+		}
+		r.decoder.Good()
+	} else {
+		r.decoder.Bad()
+	}
+	r.frames++
+	if *recalibrate > 0 && r.frames%*recalibrate == 0 {
+		failures := r.decoder.CheckpointFailures
+		r.decoder.Recalibrate()
+		if r.decoder.CheckpointFailures != failures {
+			log.Printf("Saving calibration: %v", r.decoder.CheckpointErr)
+		}
+	}
+	if r.metrics != nil {
+		r.metrics.Update(r.decoder)
+	}
+	if *recalibrate > 0 && r.frames%*recalibrate == 0 {
+		r.health()
+	}
+	if res.Invalid != 0 && !*all {
+		return
+	}
+	reading := lcd.NewReading(res, f.Time)
+	for _, p := range r.pubs {
+		if err := p.publish(reading); err != nil {
+			log.Printf("Publish: %v", err)
+		}
+	}
+}
+
+// Publish the calibration state to the publishers that report health.
+func (r *reader) health() {
+	sum := r.decoder.Summary()
+	for _, p := range r.pubs {
+		if h, ok := p.(healthPublisher); ok {
+			if err := h.health(sum); err != nil {
+				log.Printf("Publish health: %v", err)
+			}
+		}
+	}
+}
+
+// Sleep for the duration, returning false if stopped.
+func (r *reader) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return !r.stopped()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.stop:
+		return false
+	}
+}
+
+// Return true if the reader has been stopped.
+func (r *reader) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Save the calibration and close the source and publishers.
+func (r *reader) shutdown() {
+	if r.decoder.Store != nil && r.frames > 0 {
+		if *recalibrate <= 0 || r.frames%*recalibrate != 0 {
+			// Include the frames since the last recalibration (or all
+			// the frames, if periodic recalibration is disabled).
+			r.decoder.Recalibrate()
+		}
+		if err := r.decoder.Checkpoint(); err != nil {
+			log.Printf("Saving calibration: %v", err)
+		} else {
+			log.Printf("Calibration saved to %s", r.conf.Calibration)
+		}
+	}
+	r.src.Close()
+	if r.history != nil {
+		if err := r.history.Close(); err != nil {
+			log.Printf("History: %v", err)
+		}
+	}
+	for _, p := range r.pubs {
+		if err := p.close(); err != nil {
+			log.Printf("Close: %v", err)
+		}
+	}
+}
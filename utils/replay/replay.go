@@ -0,0 +1,154 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aamcrae/lcd"
+)
+
+var configFile = flag.String("config", "config", "Configuration file")
+var calFile = flag.String("calibration", "", "Calibration file, overriding the config file")
+var format = flag.String("format", "csv", "Output format (csv or json)")
+var changed = flag.Bool("changed", false, "Only output the frames where the decode has changed")
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] history-directory ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+}
+
+// result is the comparison of the logged and replayed decodes of one frame.
+type result struct {
+	Time          time.Time `json:"time"`
+	Frame         string    `json:"frame"`
+	Marked        string    `json:"marked"`
+	Invalid       int       `json:"invalid"`
+	Confidence    int       `json:"confidence"`
+	NewMarked     string    `json:"new_marked"`
+	NewInvalid    int       `json:"new_invalid"`
+	NewConfidence int       `json:"new_confidence"`
+	Changed       bool      `json:"changed"`
+	Error         string    `json:"error,omitempty"`
+}
+
+func main() {
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	conf, err := lcd.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(*calFile) != 0 {
+		conf.Calibration = *calFile
+	}
+	l, err := conf.Decoder()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	w := newWriter(*format)
+	var entries, replayed, fixed, broken, diff, failed int
+	for _, dir := range flag.Args() {
+		el, err := lcd.LoadHistory(dir)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, e := range el {
+			entries++
+			if len(e.Frame) == 0 {
+				continue
+			}
+			r := &result{Time: e.Time, Frame: e.Frame, Marked: e.Marked, Invalid: e.Invalid, Confidence: e.Confidence}
+			img, err := lcd.ReadImage(e.Frame)
+			if err != nil {
+				r.Error = err.Error()
+				failed++
+				w.write(r)
+				continue
+			}
+			replayed++
+			res := l.Decode(conf.Prepare(img))
+			r.NewMarked = res.Marked()
+			r.NewInvalid = res.Invalid
+			r.NewConfidence = res.Confidence
+			if res.Err != nil {
+				r.Error = res.Err.Error()
+			}
+			r.Changed = r.NewMarked != r.Marked
+			if r.Changed {
+				diff++
+				if r.Invalid != 0 && r.NewInvalid == 0 {
+					fixed++
+				} else if r.Invalid == 0 && r.NewInvalid != 0 {
+					broken++
+				}
+			}
+			if r.Changed || !*changed {
+				w.write(r)
+			}
+		}
+	}
+	w.flush()
+	fmt.Fprintf(os.Stderr, "%d entries, %d frames replayed, %d errors, %d changed (%d now valid, %d now invalid)\n",
+		entries, replayed, failed, diff, fixed, broken)
+}
+
+// writer outputs the results in CSV or JSON lines format.
+type writer struct {
+	c *csv.Writer
+	j *json.Encoder
+}
+
+func newWriter(f string) *writer {
+	switch f {
+	case "csv":
+		w := &writer{c: csv.NewWriter(os.Stdout)}
+		w.c.Write([]string{"time", "frame", "marked", "invalid", "confidence", "new_marked", "new_invalid", "new_confidence", "changed", "error"})
+		return w
+	case "json":
+		return &writer{j: json.NewEncoder(os.Stdout)}
+	}
+	log.Fatalf("Unknown format %s", f)
+	return nil
+}
+
+func (w *writer) write(r *result) {
+	if w.j != nil {
+		if err := w.j.Encode(r); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	w.c.Write([]string{r.Time.Format(time.RFC3339), r.Frame, r.Marked, strconv.Itoa(r.Invalid), strconv.Itoa(r.Confidence),
+		r.NewMarked, strconv.Itoa(r.NewInvalid), strconv.Itoa(r.NewConfidence), strconv.FormatBool(r.Changed), r.Error})
+}
+
+func (w *writer) flush() {
+	if w.c != nil {
+		w.c.Flush()
+	}
+}
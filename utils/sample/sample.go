@@ -19,15 +19,11 @@ import (
 	"fmt"
 	"image"
 	"image/color"
-	"io/ioutil"
 	"log"
-	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/aamcrae/lcd"
-	"gopkg.in/yaml.v3"
 )
 
 var output = flag.String("output", "output.jpg", "output jpeg file")
@@ -42,54 +38,30 @@ func init() {
 	flag.Parse()
 }
 
-type config struct {
-	Source string
-	Rotate float64
-	Config lcd.LcdConfig
-}
-
 func main() {
-	s, err := ioutil.ReadFile(*configFile)
-	if err != nil {
-		log.Fatalf("Can't read config %s: %v", *configFile, err)
-	}
-	var conf config
-	err = yaml.Unmarshal([]byte(s), &conf)
+	conf, err := lcd.LoadConfig(*configFile)
 	if err != nil {
-		log.Fatalf("config parse fail %s: %v", *configFile, err)
+		log.Fatalf("%v", err)
 	}
 	l, err := lcd.CreateLcdDecoder(conf.Config)
 	if err != nil {
 		log.Fatalf("LCD config failed %v", err)
 	}
-	var in image.Image
-	if len(*input) == 0 {
-		client := http.Client{
-			Timeout: time.Duration(10) * time.Second,
-		}
-		res, err := client.Get(conf.Source)
-		if err != nil {
-			log.Fatalf("Failed to retrieve source image from %s: %v", conf.Source, err)
-		}
-		in, _, err = image.Decode(res.Body)
-		res.Body.Close()
-		if err != nil {
-			log.Fatalf("Failed to decode image from %s: %v", conf.Source, err)
-		}
-	} else {
-		inf, err := os.Open(*input)
-		if err != nil {
-			log.Fatalf("Failed to open %s: %v", *input, err)
-		}
-		defer inf.Close()
-		in, _, err = image.Decode(inf)
-		if err != nil {
-			log.Fatalf("Failed to read %s: %v", *input, err)
-		}
+	src := *input
+	if len(src) == 0 {
+		src = conf.Source
+	}
+	is, err := lcd.OpenSource(src, 10*time.Second)
+	if err != nil {
+		log.Fatalf("%s: %v", src, err)
 	}
-	if conf.Rotate != 0 {
-		in = lcd.RotateImage(in, conf.Rotate)
+	f, err := is.Next()
+	is.Close()
+	if err != nil {
+		log.Fatalf("Failed to read image from %s: %v", src, err)
 	}
+	in := f.Image
+	in = conf.Prepare(in)
 	if *calibrate && *decode {
 		l.Preset(in, *digits)
 	}
@@ -112,6 +84,9 @@ func main() {
 			}
 		}
 		fmt.Printf("Segments = <%s>\n", str.String())
+		if res.Err != nil {
+			fmt.Printf("%v\n", res.Err)
+		}
 	}
 	l.MarkSamples(img, *fill)
 	err = lcd.SaveImage(*output, img)
@@ -15,15 +15,13 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
-	"image"
-	"io/ioutil"
 	"log"
 	"os"
 
 	"github.com/aamcrae/lcd"
-	"gopkg.in/yaml.v3"
 )
 
 var configFile = flag.String("config", "config", "Configuration file")
@@ -32,30 +30,57 @@ var calImage = flag.String("image", "", "Calibration image")
 var calibration = flag.String("calibration", "", "Calibration cache file")
 var digits = flag.String("digits", "888888888888", "Digits for calibration")
 var rewrite = flag.String("rewrite", "", "Write calibration to file")
+var jsonOut = flag.Bool("json", false, "Output report as JSON")
 
 func init() {
 	flag.Parse()
 }
 
-type config struct {
-	Meter struct {
-		Source        string
-		Rotate        float64
-		lcd.LcdConfig `yaml:",inline"`
-	}
+// Report of the scan of one segment.
+type segReport struct {
+	Name      string `json:"name"`
+	Value     int    `json:"value"`     // Scanned value
+	Min       int    `json:"min"`       // Calibrated 'off' level
+	Max       int    `json:"max"`       // Calibrated 'on' level
+	Threshold int    `json:"threshold"` // On/off threshold
+	Margin    int    `json:"margin"`    // Percentage of min-max range the value is from the threshold
+	On        bool   `json:"on"`
+}
+
+// Report of the scan of one digit.
+type digitReport struct {
+	Index    int         `json:"index"`
+	Char     string      `json:"char"`
+	Valid    bool        `json:"valid"`
+	DP       bool        `json:"dp"`
+	DPValue  int         `json:"dp_value"`
+	Mask     int         `json:"mask"`
+	Off      int         `json:"off"` // Sample of the digit's 'off' region
+	Segments []segReport `json:"segments"`
+}
+
+type report struct {
+	Text    string        `json:"text"`
+	Invalid int           `json:"invalid"`
+	Quality int           `json:"quality"`
+	Best    int           `json:"best"`
+	Worst   int           `json:"worst"`
+	Count   int           `json:"count"`
+	Digits  []digitReport `json:"digits"`
 }
 
 func main() {
-	s, err := ioutil.ReadFile(*configFile)
+	conf, err := lcd.LoadConfig(*configFile)
 	if err != nil {
-		log.Fatalf("Can't read config %s: %v", *configFile, err)
+		log.Fatalf("%v", err)
 	}
-	var conf config
-	err = yaml.Unmarshal([]byte(s), &conf)
+	l, err := lcd.CreateLcdDecoder(conf.Config)
 	if err != nil {
-		log.Fatalf("config parse fail %s: %v", *configFile, err)
+		log.Fatalf("LCD config failed %v", err)
+	}
+	if len(*calibration) == 0 {
+		*calibration = conf.Calibration
 	}
-	l, err := lcd.CreateLcdDecoder(conf.Meter.LcdConfig)
 	if len(*calibration) > 0 {
 		if _, err := l.RestoreFromFile(*calibration); err != nil {
 			log.Fatalf("%s: %v\n", *calibration, err)
@@ -66,78 +91,97 @@ func main() {
 		if err != nil {
 			log.Fatalf("%v", err)
 		}
-		l.Preset(img, *digits)
-	}
-	inf, err := os.Open(*input)
-	if err != nil {
-		log.Fatalf("Failed to open %s: %v", *input, err)
+		if err := l.Preset(conf.Prepare(img), *digits); err != nil {
+			log.Fatalf("%s: %v", *calImage, err)
+		}
 	}
-	defer inf.Close()
-	img, _, err := image.Decode(inf)
+	img, err := lcd.ReadImage(*input)
 	if err != nil {
 		log.Fatalf("Failed to read %s: %v", *input, err)
 	}
-	if conf.Meter.Rotate != 0 {
-		img = lcd.RotateImage(img, conf.Meter.Rotate)
-	}
+	img = conf.Prepare(img)
 	res := l.Decode(img)
-	for i := range res.Decodes {
-		d := res.Decodes[i]
-		fmt.Printf("segment %d = '%s', ok = %v, bits = %02x\n", i, d.Str, d.Valid, res.Scans[i].Mask)
+	if res.Err != nil {
+		log.Printf("%s: %v", *input, res.Err)
 	}
-	fmt.Printf("Digit |  Off |  TL  |  TM  |  TR  |  BR  |  BM  |  BL  |  MM  |\n")
-	for i, _ := range l.Digits {
-		min := []int{} // d.Min()
-		off := 0       // d.Off(img)
-		max := []int{} // d.Max()
-		fmt.Printf("  %-2d  | %-5d|", i, off)
-		for _, v := range res.Scans[i].Segments {
-			fmt.Printf(" %-5d|", v)
-		}
-		fmt.Printf("\n")
-		fmt.Printf("         Min |")
-		for _, v := range min {
-			fmt.Printf(" %-5d|", v)
-		}
-		fmt.Printf("\n")
-		fmt.Printf("         Max |")
-		for _, v := range max {
-			fmt.Printf(" %-5d|", v)
+	off := l.SampleOff(img)
+	cal := l.Calibration()
+	r := report{Text: res.Text, Invalid: res.Invalid, Quality: cal.Quality, Best: l.Best, Worst: l.Worst, Count: l.Count}
+	for i, d := range res.Decodes {
+		scan := res.Scans[i]
+		dr := digitReport{Index: i, Char: d.Str, Valid: d.Valid, DP: d.DP, DPValue: scan.DP, Mask: scan.Mask, Off: off[i]}
+		for s, v := range scan.Segments {
+			sc := cal.Digits[i].Segments[s]
+			dr.Segments = append(dr.Segments, segReport{
+				Name:      lcd.SegmentName(s),
+				Value:     v,
+				Min:       sc.Min,
+				Max:       sc.Max,
+				Threshold: sc.Threshold,
+				Margin:    perc(sc.Max, sc.Min, v-sc.Threshold),
+				On:        (scan.Mask & (1 << uint(s))) != 0,
+			})
 		}
-		fmt.Printf("\n")
-		fmt.Printf("        Perc |")
-		for i, v := range res.Scans[i].Segments {
-			fmt.Printf(" %-5d|", perc(max[i], min[i], v))
+		r.Digits = append(r.Digits, dr)
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(&r); err != nil {
+			log.Fatalf("JSON: %v", err)
 		}
-		fmt.Printf("\n")
+	} else {
+		printReport(&r)
 	}
 	if len(*rewrite) > 0 {
-		out, err := os.Create(*rewrite)
-		if err != nil {
-			log.Fatalf("Failed to create %s: %v", *rewrite, err)
+		// Calibrate using the input image and the digits provided, and
+		// save the calibration in the format that Restore accepts.
+		if err := l.Preset(img, *digits); err != nil {
+			log.Fatalf("Calibration: %v", err)
 		}
-		defer out.Close()
-		b, err := lcd.DigitsToSegments(*digits)
-		if err != nil {
-			log.Fatalf("DigitsToSegments: %v", err)
+		l.Good()
+		l.Recalibrate()
+		if err := l.SaveToFile(*rewrite, 0); err != nil {
+			log.Fatalf("%s: %v", *rewrite, err)
 		}
-		if len(b) != len(l.Digits) {
-			log.Fatalf("Wrong digit count (%d), expected (%d)", len(b), len(l.Digits))
+	}
+}
+
+// Print the report as a table.
+func printReport(r *report) {
+	fmt.Printf("Decoded = <%s>, invalid = %d, quality = %d (best %d, worst %d, count %d)\n", r.Text, r.Invalid, r.Quality, r.Best, r.Worst, r.Count)
+	for _, d := range r.Digits {
+		fmt.Printf("digit %d = '%s', ok = %v, dp = %v, bits = %02x\n", d.Index, d.Char, d.Valid, d.DP, d.Mask)
+	}
+	fmt.Printf("Digit |  Off  |")
+	for s := 0; s < lcd.SEGMENTS; s++ {
+		fmt.Printf("  %-4s|", lcd.SegmentName(s))
+	}
+	fmt.Printf("\n")
+	for _, d := range r.Digits {
+		fmt.Printf("  %-2d  | %-6d|", d.Index, d.Off)
+		for _, s := range d.Segments {
+			fmt.Printf(" %-5d|", s.Value)
 		}
-		for i, _ := range l.Digits {
-			min := []int{} // d.Min()
-			max := []int{} // d.Max()
-			for j, s := range res.Scans[i].Segments {
-				if ((1 << uint(j)) & b[i]) != 0 {
-					fmt.Fprintf(out, "%d,%d,%d,%d\n", i, j, min[j], s)
-				} else {
-					fmt.Fprintf(out, "%d,%d,%d,%d\n", i, j, s, max[j])
-				}
+		fmt.Printf("\n")
+		row := func(name string, f func(s segReport) int) {
+			fmt.Printf("%13s |", name)
+			for _, s := range d.Segments {
+				fmt.Printf(" %-5d|", f(s))
 			}
+			fmt.Printf("\n")
 		}
+		row("Min", func(s segReport) int { return s.Min })
+		row("Max", func(s segReport) int { return s.Max })
+		row("Threshold", func(s segReport) int { return s.Threshold })
+		row("Margin %", func(s segReport) int { return s.Margin })
 	}
 }
 
+// Return v as a percentage of the range between min and max.
 func perc(max, min, v int) int {
-	return (v - min) * 100 / (max - min)
+	if max == min {
+		return 0
+	}
+	return v * 100 / (max - min)
 }
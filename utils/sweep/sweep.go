@@ -0,0 +1,308 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/aamcrae/lcd"
+)
+
+var configFile = flag.String("config", "config", "Configuration file")
+var calFile = flag.String("calibration", "", "Initial calibration file")
+var manifest = flag.String("manifest", "", "Manifest of labelled images")
+var thresholds = flag.String("threshold", "30,40,50,60,70", "Threshold percentages to try")
+var histories = flag.String("history", "1,3,5,10", "Moving average history sizes to try")
+var maxLevels = flag.String("maxlevels", "20,200", "Maximum calibration levels to try")
+var interval = flag.Int("interval", 10, "Number of images between each recalibration")
+var passes = flag.Int("passes", 1, "Number of times the images are replayed")
+var points = flag.Int("points", 5, "Number of calibration quality values shown")
+var workers = flag.Int("workers", 1, "Number of combinations run in parallel")
+var format = flag.String("format", "table", "Output format (table or csv)")
+
+func init() {
+	flag.Parse()
+}
+
+// params is one combination of parameter values.
+type params struct {
+	threshold int
+	history   int
+	maxLevels int
+}
+
+// result holds the results of replaying the images with one combination.
+type result struct {
+	params
+	images   int   // Images decoded
+	correct  int   // Images decoded correctly
+	digits   int   // Digits decoded
+	goodDig  int   // Digits decoded correctly
+	invalid  int   // Images with invalid digits
+	invDig   int   // Invalid digits
+	quality  []int // Quality of each recalibration
+	best     int   // Final best quality
+	worst    int   // Final worst quality
+	levels   int   // Final count of levels
+	errorMsg string
+}
+
+// entry is one labelled and prepared image.
+type entry struct {
+	lcd.ManifestEntry
+	img image.Image
+}
+
+func main() {
+	if len(*manifest) == 0 {
+		log.Fatalf("A manifest of labelled images is required")
+	}
+	conf, err := lcd.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(*calFile) != 0 {
+		conf.Calibration = *calFile
+	}
+	m, err := lcd.LoadManifest(*manifest)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	// The images are read once, and shared across all combinations.
+	var entries []entry
+	for _, e := range m {
+		img, err := lcd.ReadImage(e.Image)
+		if err != nil {
+			log.Fatalf("%s: %v", e.Image, err)
+		}
+		entries = append(entries, entry{e, conf.Prepare(img)})
+	}
+	if *interval < 1 {
+		*interval = 1
+	}
+	var grid []params
+	for _, t := range intList("threshold", *thresholds) {
+		for _, h := range intList("history", *histories) {
+			for _, ml := range intList("maxlevels", *maxLevels) {
+				grid = append(grid, params{t, h, ml})
+			}
+		}
+	}
+	results := make([]*result, len(grid))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	if *workers < 1 {
+		*workers = 1
+	}
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = replay(conf, grid[i], entries)
+			}
+		}()
+	}
+	for i := range grid {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	switch *format {
+	case "table":
+		writeTable(results)
+	case "csv":
+		writeCSV(results)
+	default:
+		log.Fatalf("Unknown format %s", *format)
+	}
+	if best := recommend(results); best != nil {
+		fmt.Fprintf(os.Stderr, "Recommended: threshold: %d, history: %d, maxlevels: %d (%d of %d images correct)\n",
+			best.threshold, best.history, best.maxLevels, best.correct, best.images)
+	}
+}
+
+// Replay the images through a decoder created with the parameters.
+// Images with a preset value are used to calibrate the decoder before decoding.
+// Valid decodes are used to calibrate the decoder, and the decoder
+// is recalibrated after each interval of images.
+func replay(conf *lcd.ConfigFile, p params, entries []entry) *result {
+	r := &result{params: p}
+	lc := conf.Config
+	lc.Threshold = p.threshold
+	lc.History = p.history
+	lc.MaxLevels = p.maxLevels
+	c := *conf
+	c.Config = lc
+	l, err := c.Decoder()
+	if err != nil {
+		r.errorMsg = err.Error()
+		return r
+	}
+	var n int
+	for pass := 0; pass < *passes; pass++ {
+		for _, e := range entries {
+			if len(e.Preset) != 0 {
+				if err := l.Preset(e.img, e.Preset); err != nil {
+					r.errorMsg = fmt.Sprintf("%s: %v", e.Image, err)
+					return r
+				}
+			}
+			res := l.Decode(e.img)
+			r.images++
+			good, total := lcd.CompareDigits(e.Expected, res.Marked())
+			if good == total {
+				r.correct++
+			}
+			if res.Invalid != 0 {
+				r.invalid++
+				r.invDig += res.Invalid
+				l.Bad()
+			} else {
+				l.CalibrateUsingScan(e.img, res.Scans)
+				l.Good()
+			}
+			r.goodDig += good
+			r.digits += total
+			n++
+			if n%*interval == 0 {
+				l.Recalibrate()
+				r.quality = append(r.quality, l.LastQuality)
+			}
+		}
+	}
+	r.best, r.worst, r.levels = l.Best, l.Worst, l.Count
+	return r
+}
+
+// Return the recommended result, which has the most correct images, the
+// fewest invalid digits, and the highest final calibration quality.
+// Remaining ties are broken using the threshold closest to the midpoint.
+func recommend(results []*result) *result {
+	var ok []*result
+	for _, r := range results {
+		if len(r.errorMsg) == 0 {
+			ok = append(ok, r)
+		}
+	}
+	if len(ok) == 0 {
+		return nil
+	}
+	sort.SliceStable(ok, func(i, j int) bool {
+		a, b := ok[i], ok[j]
+		if a.correct != b.correct {
+			return a.correct > b.correct
+		}
+		if a.invDig != b.invDig {
+			return a.invDig < b.invDig
+		}
+		if a.best != b.best {
+			return a.best > b.best
+		}
+		if a.worst != b.worst {
+			return a.worst > b.worst
+		}
+		return abs(a.threshold-50) < abs(b.threshold-50)
+	})
+	return ok[0]
+}
+
+func writeTable(results []*result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Threshold\tHistory\tMaxLevels\tImages\tCorrect %\tDigits %\tInvalid %\tInvalid digits\tBest\tWorst\tLevels\tQuality\t")
+	for _, r := range results {
+		if len(r.errorMsg) != 0 {
+			fmt.Fprintf(w, "%d\t%d\t%d\t%s\t\n", r.threshold, r.history, r.maxLevels, r.errorMsg)
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\t\n", r.threshold, r.history, r.maxLevels,
+			r.images, perc(r.correct, r.images), perc(r.goodDig, r.digits), perc(r.invalid, r.images),
+			r.invDig, r.best, r.worst, r.levels, qualities(r.quality))
+	}
+	w.Flush()
+}
+
+func writeCSV(results []*result) {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"threshold", "history", "maxlevels", "images", "correct", "digits", "correct_digits", "invalid", "invalid_digits", "best", "worst", "levels", "quality", "error"})
+	for _, r := range results {
+		var q []string
+		for _, v := range r.quality {
+			q = append(q, strconv.Itoa(v))
+		}
+		w.Write([]string{strconv.Itoa(r.threshold), strconv.Itoa(r.history), strconv.Itoa(r.maxLevels),
+			strconv.Itoa(r.images), strconv.Itoa(r.correct), strconv.Itoa(r.digits), strconv.Itoa(r.goodDig),
+			strconv.Itoa(r.invalid), strconv.Itoa(r.invDig), strconv.Itoa(r.best), strconv.Itoa(r.worst),
+			strconv.Itoa(r.levels), strings.Join(q, ";"), r.errorMsg})
+	}
+	w.Flush()
+}
+
+// Return a sample of the quality values, evenly spaced across the replay.
+func qualities(q []int) string {
+	if len(q) == 0 {
+		return "-"
+	}
+	n := *points
+	if n > len(q) {
+		n = len(q)
+	}
+	var s []string
+	for i := 0; i < n; i++ {
+		idx := 0
+		if n > 1 {
+			idx = i * (len(q) - 1) / (n - 1)
+		}
+		s = append(s, strconv.Itoa(q[idx]))
+	}
+	return strings.Join(s, ",")
+}
+
+func perc(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", float64(n)*100/float64(total))
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Parse a comma separated list of integers.
+func intList(name, s string) []int {
+	var l []int
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || v <= 0 {
+			log.Fatalf("%s: invalid value %q", name, f)
+		}
+		l = append(l, v)
+	}
+	return l
+}
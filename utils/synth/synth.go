@@ -0,0 +1,125 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/aamcrae/lcd"
+)
+
+var configFile = flag.String("config", "config", "Configuration file")
+var dir = flag.String("dir", ".", "Output directory")
+var prefix = flag.String("prefix", "synth", "Prefix of image and manifest file names")
+var count = flag.Int("count", 0, "Number of images with random digits to generate")
+var led = flag.Bool("led", false, "Render a LED display (lighter is on)")
+var box = flag.Bool("box", false, "Render rectangular segments")
+var thickness = flag.Float64("thickness", 1.0, "Segment width as a fraction of the template width")
+var gap = flag.Float64("gap", 1, "Gap in pixels between segments")
+var skew = flag.Float64("skew", 0, "Digit skew in degrees")
+var ghost = flag.Float64("ghost", 0, "Visibility (0-1) of off segments")
+var gradient = flag.Float64("gradient", 0, "Brightness gradient (0-1) across the image")
+var glare = flag.Float64("glare", 0, "Brightness (0-1) of glare spot")
+var blur = flag.Int("blur", 0, "Blur radius in pixels")
+var noise = flag.Float64("noise", 0, "Standard deviation of noise in gray levels")
+var quality = flag.Int("quality", 0, "JPEG quality used to add compression artefacts")
+var seed = flag.Int64("seed", 1, "Random seed")
+var dpProb = flag.Float64("dp", 0.2, "Probability of a decimal point on random digits")
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [digits ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+}
+
+func main() {
+	conf, err := lcd.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *led {
+		conf.Config.Inverse = true
+	}
+	opt := lcd.SynthOptions{
+		Thickness: *thickness,
+		Gap:       *gap,
+		Skew:      *skew,
+		Ghost:     *ghost,
+		Gradient:  *gradient,
+		Glare:     *glare,
+		Blur:      *blur,
+		Noise:     *noise,
+		Quality:   *quality,
+	}
+	if *box {
+		opt.Shape = lcd.SegmentBox
+	}
+	texts := flag.Args()
+	rng := rand.New(rand.NewSource(*seed))
+	for i := 0; i < *count; i++ {
+		texts = append(texts, randomDigits(conf, rng))
+	}
+	if len(texts) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	mname := filepath.Join(*dir, *prefix+".manifest")
+	mf, err := os.Create(mname)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	for i, s := range texts {
+		opt.Seed = *seed + int64(i)
+		syn, err := lcd.Synthesize(conf.Config, s, opt)
+		if err != nil {
+			log.Fatalf("%q: %v", s, err)
+		}
+		name := fmt.Sprintf("%s_%04d.png", *prefix, i)
+		if err := lcd.SaveImage(filepath.Join(*dir, name), syn.Image); err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		if err := lcd.WriteManifestEntry(mf, lcd.ManifestEntry{Image: name, Expected: syn.Text}); err != nil {
+			log.Fatalf("%s: %v", mname, err)
+		}
+	}
+	if err := mf.Close(); err != nil {
+		log.Fatalf("%s: %v", mname, err)
+	}
+	fmt.Printf("%d images written, manifest %s\n", len(texts), mname)
+}
+
+// Generate a random string of digits, with random decimal points on the
+// digits that have a decimal point.
+func randomDigits(conf *lcd.ConfigFile, rng *rand.Rand) string {
+	dp := make(map[string]bool)
+	for _, t := range conf.Config.Lcd {
+		dp[t.Name] = len(t.Dp) == 2
+	}
+	var s []byte
+	for _, d := range conf.Config.Digit {
+		s = append(s, byte('0'+rng.Intn(10)))
+		if dp[d.Lcd] && rng.Float64() < *dpProb {
+			s = append(s, '.')
+		}
+	}
+	return string(s)
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aamcrae/lcd"
+)
+
+var configFile = flag.String("config", "config", "Configuration file")
+var imageFile = flag.String("image", "", "Image used to check that the digits are inside the image, if the size is not configured")
+
+func init() {
+	flag.Parse()
+}
+
+func main() {
+	conf, err := lcd.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	var findings []string
+	if len(*imageFile) != 0 {
+		img, err := lcd.ReadImage(*imageFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		findings = lcd.ValidateConfigImage(conf.Config, conf.Prepare(img))
+	} else {
+		findings = lcd.ValidateConfig(conf.Config)
+		if conf.Config.Size[0] == 0 && conf.Config.Size[1] == 0 {
+			fmt.Printf("%s: no image size or --image, so digits and decimal points are not checked against the image\n", *configFile)
+		}
+	}
+	for _, f := range findings {
+		fmt.Printf("%s: %s\n", *configFile, f)
+	}
+	if len(findings) != 0 {
+		os.Exit(1)
+	}
+	fmt.Printf("%s: OK\n", *configFile)
+}
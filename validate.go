@@ -0,0 +1,158 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"fmt"
+	"image"
+	"sort"
+)
+
+// ValidateConfig checks the configuration and the geometry of the
+// templates and digits it describes, and returns a list of
+// human readable findings. An empty list indicates no problems were found.
+// Unlike CreateLcdDecoder, validation continues after an error is found so
+// that all problems can be reported at once.
+// If the image size is set in the configuration, the digits and decimal points
+// are checked to be inside the image.
+func ValidateConfig(conf LcdConfig) []string {
+	var r image.Rectangle
+	if conf.Size[0] != 0 || conf.Size[1] != 0 {
+		r = image.Rect(0, 0, conf.Size[0], conf.Size[1])
+	}
+	return validateConfig(conf, r)
+}
+
+// ValidateConfigImage checks the configuration as ValidateConfig does, and also
+// checks that the digits and decimal points are inside the image, which
+// should be prepared (rotated and cropped) in the same way as the images decoded.
+func ValidateConfigImage(conf LcdConfig, img image.Image) []string {
+	return validateConfig(conf, img.Bounds())
+}
+
+// Validate the configuration, checking the digits against the image bounds
+// unless the bounds are empty.
+func validateConfig(conf LcdConfig, bounds image.Rectangle) []string {
+	var f []string
+	if len(conf.Lcd) == 0 {
+		f = append(f, "No LCDs defined")
+	}
+	if len(conf.Digit) == 0 {
+		f = append(f, "No digits defined")
+	}
+	l := NewLcdDecoder()
+	for _, e := range conf.Lcd {
+		if _, ok := l.templates[e.Name]; ok {
+			f = append(f, fmt.Sprintf("lcd %s: duplicate template name", e.Name))
+			continue
+		}
+		if err := checkTemplate(e); err != nil {
+			f = append(f, fmt.Sprintf("lcd %s: %v", e.Name, err))
+			continue
+		}
+		t, err := newTemplate(e)
+		if err != nil {
+			f = append(f, fmt.Sprintf("lcd %s: %v", e.Name, err))
+			continue
+		}
+		l.templates[e.Name] = t
+	}
+	for i, e := range conf.Digit {
+		e.Coord[0] += conf.Offset[0]
+		e.Coord[1] += conf.Offset[1]
+		if _, err := l.AddDigit(e); err != nil {
+			f = append(f, fmt.Sprintf("digit %d: %v", i, err))
+		}
+	}
+	f = append(f, l.Validate()...)
+	if !bounds.Empty() {
+		if err := l.CheckBounds(bounds); err != nil {
+			if be, ok := err.(*BoundsError); ok {
+				for _, s := range be.Outside {
+					f = append(f, fmt.Sprintf("%s is outside the image size %dx%d", s, bounds.Dx(), bounds.Dy()))
+				}
+			} else {
+				f = append(f, err.Error())
+			}
+		}
+	}
+	return f
+}
+
+// Validate checks the geometry of the templates and digits of the decoder,
+// and returns a list of human readable findings.
+func (l *LcdDecoder) Validate() []string {
+	var f []string
+	// Check templates in name order so that the findings are stable.
+	var names []string
+	for n := range l.templates {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		for _, s := range l.templates[n].check() {
+			f = append(f, fmt.Sprintf("lcd %s: %s", n, s))
+		}
+	}
+	// Check that the digits do not overlap each other.
+	for i, d := range l.Digits {
+		for _, d2 := range l.Digits[i+1:] {
+			if d.bb.Overlaps(d2.bb) {
+				f = append(f, fmt.Sprintf("digit %d: overlaps digit %d", d.index, d2.index))
+			}
+		}
+	}
+	return f
+}
+
+// check validates the geometry of the template, returning a list of
+// problems found.
+func (t *Template) check() []string {
+	var f []string
+	for i := range t.bb {
+		for j := i + 1; j < len(t.bb); j++ {
+			if t.bb[i] == t.bb[j] {
+				f = append(f, fmt.Sprintf("corners %d and %d are the same point", i, j))
+			}
+		}
+	}
+	if t.bb.Area() == 0 {
+		f = append(f, "corners are degenerate (zero area)")
+	}
+	if intersect(t.bb[TL], t.bb[TR], t.bb[BR], t.bb[BL]) || intersect(t.bb[TR], t.bb[BR], t.bb[BL], t.bb[TL]) {
+		f = append(f, "outline is self-intersecting")
+	}
+	// Build a set of the off points to check for overlap with the segments.
+	off := make(map[Point]struct{})
+	for _, p := range t.off {
+		off[p] = struct{}{}
+	}
+	if len(off) == 0 {
+		f = append(f, "off region is empty")
+	}
+	for i := range t.seg {
+		if len(t.seg[i].points) == 0 {
+			f = append(f, fmt.Sprintf("segment %s has no points", segNames[i]))
+			continue
+		}
+		for _, p := range t.seg[i].points {
+			if _, ok := off[p]; ok {
+				f = append(f, fmt.Sprintf("segment %s overlaps the off region", segNames[i]))
+				break
+			}
+		}
+	}
+	return f
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// ConfigWatcher monitors a configuration file, and when the file changes,
+// reloads the configuration and rebuilds the decoder, migrating the
+// calibration levels from the previous decoder.
+// The watcher is not safe for concurrent use; Check is intended to be
+// called from the same goroutine that is using the decoder e.g
+// before each image is decoded.
+type ConfigWatcher struct {
+	name    string
+	mod     time.Time
+	size    int64
+	conf    *ConfigFile
+	decoder *LcdDecoder
+}
+
+// ConfigEvent describes the changes made when a configuration is reloaded.
+type ConfigEvent struct {
+	Config   *ConfigFile // New configuration
+	Decoder  *LcdDecoder // New decoder
+	Migrated []int       // Digits that had their calibration migrated
+	Reset    []int       // Digits that are new or have a changed template
+	Removed  int         // Count of digits removed
+}
+
+// Return a summary of the changes.
+func (e *ConfigEvent) String() string {
+	return fmt.Sprintf("%d digits, migrated %v, reset %v, removed %d", len(e.Decoder.Digits), e.Migrated, e.Reset, e.Removed)
+}
+
+// NewConfigWatcher loads the configuration file and creates a decoder
+// (restoring the calibration if one is configured).
+func NewConfigWatcher(name string) (*ConfigWatcher, error) {
+	w := &ConfigWatcher{name: name}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := LoadConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	d, err := conf.Decoder()
+	if err != nil {
+		return nil, err
+	}
+	w.mod, w.size = fi.ModTime(), fi.Size()
+	w.conf = conf
+	w.decoder = d
+	return w, nil
+}
+
+// Config returns the current configuration.
+func (w *ConfigWatcher) Config() *ConfigFile {
+	return w.conf
+}
+
+// Decoder returns the current decoder.
+func (w *ConfigWatcher) Decoder() *LcdDecoder {
+	return w.decoder
+}
+
+// SetDecoder replaces the current decoder, e.g with a decoder restored
+// from a different calibration. The decoder must have been created from
+// the current configuration.
+func (w *ConfigWatcher) SetDecoder(d *LcdDecoder) {
+	w.decoder = d
+}
+
+// Check tests whether the configuration file has changed, and if so reloads
+// the configuration and creates a new decoder, migrating the calibration of
+// digits whose index and template are unchanged.
+// If the file has not changed, nil is returned.
+// If the new configuration is invalid, an error is returned and the current
+// configuration and decoder are retained.
+func (w *ConfigWatcher) Check() (*ConfigEvent, error) {
+	fi, err := os.Stat(w.name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.ModTime() == w.mod && fi.Size() == w.size {
+		return nil, nil
+	}
+	// Record the modification so that a bad file is only reported once.
+	w.mod, w.size = fi.ModTime(), fi.Size()
+	conf, err := LoadConfig(w.name)
+	if err != nil {
+		return nil, err
+	}
+	d, err := CreateLcdDecoder(conf.Config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", w.name, err)
+	}
+	e := &ConfigEvent{Config: conf, Decoder: d}
+	e.Migrated = d.MigrateCalibration(w.decoder)
+	m := make(map[int]bool)
+	for _, i := range e.Migrated {
+		m[i] = true
+	}
+	for i := range d.Digits {
+		if !m[i] {
+			e.Reset = append(e.Reset, i)
+		}
+	}
+	if len(w.decoder.Digits) > len(d.Digits) {
+		e.Removed = len(w.decoder.Digits) - len(d.Digits)
+	}
+	w.conf = conf
+	w.decoder = d
+	return e, nil
+}
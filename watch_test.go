@@ -0,0 +1,170 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lcd_test
+
+import (
+	"testing"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aamcrae/lcd"
+)
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lcdwatch")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	orig, err := ioutil.ReadFile(filepath.Join("testdata", "test1.config"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	cname := filepath.Join(dir, "test.config")
+	write := func(s string, mod time.Time) {
+		if err := ioutil.WriteFile(cname, []byte(s), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		os.Chtimes(cname, mod, mod)
+	}
+	now := time.Now()
+	write(string(orig), now)
+	w, err := lcd.NewConfigWatcher(cname)
+	if err != nil {
+		t.Fatalf("NewConfigWatcher: %v", err)
+	}
+	if e, err := w.Check(); e != nil || err != nil {
+		t.Fatalf("Unexpected change: %v, %v", e, err)
+	}
+	img := readImage(t, "test1.jpg")
+	d := w.Decoder()
+	if err := d.Preset(img, "12345678"); err != nil {
+		t.Fatalf("Preset: %v", err)
+	}
+	d.Good()
+	d.Recalibrate()
+	// Move the last digit to a new template with the same geometry.
+	changed := strings.Replace(string(orig), "digit:", `  - name: B
+    tl: [5, 5]
+    tr: [33,6]
+    br: [29,80]
+    bl: [0,80]
+    dp: [37,84]
+    width: 8
+digit:`, 1)
+	changed = strings.Replace(changed, "  - lcd: A\n    coord: [555,349]", "  - lcd: B\n    coord: [555,349]", 1)
+	write(changed, now.Add(time.Second))
+	e, err := w.Check()
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if e == nil {
+		t.Fatalf("Expected config change event")
+	}
+	if len(e.Migrated) != 7 || len(e.Reset) != 1 || e.Reset[0] != 7 || e.Removed != 0 {
+		t.Errorf("Unexpected event: %s", e)
+	}
+	if w.Decoder() != e.Decoder || w.Decoder() == d {
+		t.Errorf("Decoder not updated")
+	}
+	// The migrated digits should still decode.
+	res := e.Decoder.Decode(img)
+	for i := 0; i < 7; i++ {
+		if !res.Decodes[i].Valid || res.Decodes[i].Char != byte('1'+i) {
+			t.Errorf("Digit %d not decoded after migration", i)
+		}
+	}
+	// A bad config retains the current decoder.
+	write("lcd: [\n", now.Add(2*time.Second))
+	if _, err := w.Check(); err == nil {
+		t.Errorf("Expected error from bad config")
+	}
+	if w.Decoder() != e.Decoder {
+		t.Errorf("Decoder changed after bad config")
+	}
+}
+
+func TestMigrateCalibration(t *testing.T) {
+	conf := readConfig(t, "test1.config")
+	old, err := lcd.CreateLcdDecoder(conf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	l, err := lcd.CreateLcdDecoder(conf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Nothing is migrated from an uncalibrated decoder.
+	if m := l.MigrateCalibration(old); m != nil {
+		t.Errorf("Migrated %v from uncalibrated decoder", m)
+	}
+	if l.Calibration() != nil {
+		t.Errorf("Calibration present after migration from uncalibrated decoder")
+	}
+	img := readImage(t, "test1.jpg")
+	if err := old.Preset(img, "12345678"); err != nil {
+		t.Fatalf("Preset: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		old.Good()
+		old.Recalibrate()
+	}
+	// Nothing is migrated if the polarity has changed.
+	conf.Inverse = !conf.Inverse
+	l, err = lcd.CreateLcdDecoder(conf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if m := l.MigrateCalibration(old); m != nil {
+		t.Errorf("Migrated %v after polarity change", m)
+	}
+	conf.Inverse = !conf.Inverse
+	// A changed threshold, history size and maximum levels are applied to the migrated levels.
+	conf.Threshold = 25
+	conf.History = 2
+	conf.MaxLevels = 2
+	l, err = lcd.CreateLcdDecoder(conf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if m := l.MigrateCalibration(old); len(m) != 8 {
+		t.Fatalf("Expected 8 migrated digits, got %v", m)
+	}
+	if old.Count <= 2 || l.Count != 2 || len(l.Calibrations()) != 2 {
+		t.Errorf("Expected 2 levels (from %d), got %d", old.Count, l.Count)
+	}
+	oc, nc := old.Calibration(), l.Calibration()
+	for i, d := range nc.Digits {
+		od := oc.Digits[i]
+		if d.Min != od.Min || d.Max != od.Max {
+			t.Errorf("Digit %d: levels changed from %d-%d to %d-%d", i, od.Min, od.Max, d.Min, d.Max)
+		}
+		if want := d.Min + (d.Max-d.Min)*25/100; d.Threshold != want || d.Threshold == od.Threshold {
+			t.Errorf("Digit %d: threshold %d (was %d), expected %d", i, d.Threshold, od.Threshold, want)
+		}
+		for s, sc := range d.Segments {
+			if want := sc.Min + (sc.Max-sc.Min)*25/100; sc.Threshold != want {
+				t.Errorf("Digit %d segment %d: threshold %d, expected %d", i, s, sc.Threshold, want)
+			}
+			if len(sc.MinHistory) > 2 || len(sc.MaxHistory) > 2 {
+				t.Errorf("Digit %d segment %d: history not resized (%d, %d)", i, s, len(sc.MinHistory), len(sc.MaxHistory))
+			}
+		}
+	}
+}